@@ -1,16 +1,57 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/brycekahle/prerender/cache"
 	"github.com/brycekahle/prerender/render"
 )
 
+// statusClientClosedRequest is the non-standard status popularized by nginx
+// for requests the client abandoned before a response was written
+const statusClientClosedRequest = 499
+
+// statsPath reports renderer statistics. It cannot collide with
+// a render request since those require an absolute URL.
+const statsPath = "/_stats"
+
+// lockPollInterval is how often the cache is checked while
+// another API instance is rendering the requested URL
+const lockPollInterval = 100 * time.Millisecond
+
+// the lock must outlast a render with the default page load timeout
+const (
+	defaultLockTTL  = 90 * time.Second
+	defaultLockWait = 30 * time.Second
+)
+
+// lockSettings control the render lock shared between API instances
+type lockSettings struct {
+	// ttl bounds how long the lock is held if its owner crashes
+	ttl time.Duration
+	// wait bounds how long a request waits for another instance's
+	// render, zero disables the lock
+	wait time.Duration
+}
+
+// cacheStatusHeader tells whether the page was fresh or
+// stale and is being rendered again in the background
+const cacheStatusHeader = "X-Prerender-Cache"
+
 func handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == statsPath {
+		handleStats(w, r)
+		return
+	}
+
 	reqURL := r.URL.Path[1:]
 	if reqURL == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -24,40 +65,319 @@ func handle(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "Invalid URL")
 		return
 	}
-	r.URL.Path = reqURL
+	// the query of the request belongs to the target URL
+	if r.URL.RawQuery != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += r.URL.RawQuery
+	}
+	r.URL.Path = getNormalizer(r.Context()).normalize(u)
+	r.URL.RawQuery = ""
 
-	res, err := getData(r)
+	opts, err := renderOptions(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	// the caches key results by the device class and redirect handling in these headers
+	device := opts.Device
+	if device == "" {
+		device = render.DeviceDesktop
+	}
+	r.Header.Set(cache.DeviceHeader, string(device))
+	r.Header.Set(cache.FollowRedirectsHeader, strconv.FormatBool(!opts.ReturnRedirects))
+	w.Header().Add("Vary", cache.DeviceHeader)
+	w.Header().Add("Vary", cache.FollowRedirectsHeader)
+	if getDefaults(r.Context()).detectDevice {
+		w.Header().Add("Vary", "User-Agent")
+	}
+
+	res, err := getData(r, opts)
 	writeResult(res, err, w)
 }
 
-func getData(r *http.Request) (*render.Result, error) {
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	renderer := getRenderer(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(renderer.Stats())
+}
+
+func getData(r *http.Request, opts render.Options) (*render.Result, error) {
 	cache := getCache(r.Context())
-	if cache != nil {
+	if cache != nil && !customOptions(r) {
 		res, err := cache.Check(r)
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			if stale(res) {
+				revalidate(r.Context(), r.URL.Path, opts)
+			}
+			return res, nil
+		}
+		return coalesceRender(r, cache, opts)
+	}
+
+	renderer := getRenderer(r.Context())
+	return renderer.Render(r.Context(), r.URL.Path, opts)
+}
+
+// rendering holds the cache misses being rendered by this instance by render.Key
+var rendering = struct {
+	sync.Mutex
+	calls map[string]*renderCall
+}{calls: map[string]*renderCall{}}
+
+// renderCall is a render of a cache miss shared by concurrent requests
+type renderCall struct {
+	done chan struct{}
+	// res is only set if the page was rendered rather than
+	// found in the cache while waiting for the render lock
+	res *render.Result
+	err error
+}
+
+// coalesceRender renders a page missing from the cache once for all concurrent
+// requests of this instance with the same options, which would otherwise poll the render lock.
+// Requests joining a render check the cache once it is done, and share the
+// rendered result if it could not be cached.
+func coalesceRender(r *http.Request, c cache.Cache, opts render.Options) (*render.Result, error) {
+	key, err := render.Key(r.URL.Path, opts)
+	if err != nil {
+		res, _, err := renderMiss(r, c, opts)
+		return res, err
+	}
+	for {
+		rendering.Lock()
+		call, ok := rendering.calls[key]
+		if !ok {
+			call = &renderCall{done: make(chan struct{})}
+			rendering.calls[key] = call
+		}
+		rendering.Unlock()
+
+		if !ok {
+			res, rendered, err := renderMiss(r, c, opts)
+			if rendered {
+				call.res = res
+			}
+			call.err = err
+			rendering.Lock()
+			delete(rendering.calls, key)
+			rendering.Unlock()
+			close(call.done)
+			return res, err
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-call.done:
+		}
+		// a render abandoned by its request is started again below
+		if call.err != nil && call.err != context.Canceled {
+			return nil, call.err
+		}
+		res, err := c.Check(r)
 		if err != nil || res != nil {
 			return res, err
 		}
+		if call.res != nil {
+			// callers may modify their result
+			res := *call.res
+			return &res, nil
+		}
+	}
+}
+
+// renderMiss renders a page missing from the cache while holding the render
+// lock, and caches the result. rendered is false if the result of another
+// instance's render was found in the cache instead.
+func renderMiss(r *http.Request, c cache.Cache, opts render.Options) (res *render.Result, rendered bool, err error) {
+	unlock, res, err := lockRender(r, c, opts)
+	if err != nil || res != nil {
+		return res, false, err
+	}
+	if unlock != nil {
+		defer unlock()
 	}
 
 	renderer := getRenderer(r.Context())
-	res, err := renderer.Render(r.URL.Path)
-	if err == nil && res.Status == http.StatusOK && cache != nil {
-		err = cache.Save(res, 24*time.Hour)
+	res, err = renderer.Render(r.Context(), r.URL.Path, opts)
+	policy := getTTLPolicy(r.Context())
+	switch {
+	case err == nil:
+		err = saveResult(c, policy, res)
+	case isTimeout(err) && policy.Timeout > 0:
+		// later requests get the 504 without waiting for the timeout again
+		timeout := &render.Result{URL: r.URL.Path, Device: opts.Device, ReturnRedirects: opts.ReturnRedirects, Status: http.StatusGatewayTimeout}
+		if serr := c.Save(timeout, policy.Timeout); serr != nil {
+			log.WithError(serr).Errorf("error caching render timeout")
+		}
+	}
+	return res, true, err
+}
+
+// lockRender makes sure a single API instance renders the URL at a time.
+// It waits for another instance's render and returns its cached result,
+// or returns a function releasing the lock if the caller should render.
+// Both are nil if the caller should render without holding the lock.
+func lockRender(r *http.Request, c cache.Cache, opts render.Options) (func(), *render.Result, error) {
+	settings := getLockSettings(r.Context())
+	locker, ok := c.(cache.Locker)
+	if !ok || settings.wait <= 0 {
+		return nil, nil, nil
+	}
+
+	deadline := time.Now().Add(settings.wait)
+	for {
+		unlock, err := locker.Lock(cache.Key(r.URL.Path, opts.Device, opts.ReturnRedirects), settings.ttl)
+		if err == nil {
+			release := func() {
+				if err := unlock(); err != nil {
+					log.WithError(err).Errorf("error releasing render lock")
+				}
+			}
+			// the previous holder may have cached the result just before releasing the lock
+			res, err := c.Check(r)
+			if err != nil || res != nil {
+				release()
+				return nil, res, err
+			}
+			return release, nil, nil
+		}
+		if err != cache.ErrLocked {
+			log.WithError(err).Errorf("error acquiring render lock")
+			return nil, nil, nil
+		}
+		// the other render may not be cacheable or take too long
+		if time.Now().After(deadline) {
+			return nil, nil, nil
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil, nil, r.Context().Err()
+		case <-time.After(lockPollInterval):
+		}
+		res, err := c.Check(r)
+		if err != nil || res != nil {
+			return nil, res, err
+		}
+	}
+}
+
+func isTimeout(err error) bool {
+	return err == render.ErrPageLoadTimeout || err == render.ErrSelectorTimeout
+}
+
+// saveResult caches a rendered page for as long as the TTL policy allows
+func saveResult(c cache.Cache, policy cache.TTLPolicy, res *render.Result) error {
+	// redirected content must not be stored under the source URL,
+	// unless the redirect itself is returned
+	if len(res.Redirects) > 0 && !res.ReturnRedirects {
+		return nil
+	}
+	if res.Status != http.StatusOK {
+		if ttl, ok := policy.NegativeTTL(res.Status); ok {
+			return c.Save(res, ttl)
+		}
+		return nil
+	}
+	ttl, ok := policy.TTL(res.CacheHeaders, time.Now())
+	if !ok {
+		return nil
+	}
+	if policy.Stale > 0 {
+		res.FreshUntil = time.Now().Add(ttl)
 	}
-	return res, err
+	return c.Save(res, ttl+policy.Stale)
+}
+
+func stale(res *render.Result) bool {
+	return !res.FreshUntil.IsZero() && time.Now().After(res.FreshUntil)
+}
+
+// revalidating holds the cache keys being rendered again in the background
+var revalidating = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+// revalidate renders the URL in the background and refreshes the cached result,
+// unless a background render for the URL and device is already in progress
+func revalidate(ctx context.Context, url string, opts render.Options) {
+	key := cache.Key(url, opts.Device, opts.ReturnRedirects)
+	revalidating.Lock()
+	if revalidating.keys[key] {
+		revalidating.Unlock()
+		return
+	}
+	revalidating.keys[key] = true
+	revalidating.Unlock()
+
+	renderer, c, policy, lock := getRenderer(ctx), getCache(ctx), getTTLPolicy(ctx), getLockSettings(ctx)
+	go func() {
+		defer func() {
+			revalidating.Lock()
+			delete(revalidating.keys, key)
+			revalidating.Unlock()
+		}()
+
+		// another instance is already refreshing the result
+		if locker, ok := c.(cache.Locker); ok && lock.wait > 0 {
+			unlock, err := locker.Lock(key, lock.ttl)
+			if err == cache.ErrLocked {
+				return
+			}
+			if err == nil {
+				defer unlock()
+			}
+		}
+
+		// the request that found the stale result does not wait for this render
+		res, err := renderer.Render(context.Background(), url, opts)
+		if err == nil {
+			err = saveResult(c, policy, res)
+		}
+		if err != nil {
+			log.WithError(err).WithField("url", url).Warnf("background render failed")
+		}
+	}()
 }
 
 func writeResult(res *render.Result, err error, w http.ResponseWriter) {
 	if err != nil {
-		if err == render.ErrPageLoadTimeout {
+		switch err {
+		case context.Canceled:
+			// the client went away, nobody is listening for a response
+			w.WriteHeader(statusClientClosedRequest)
+		case render.ErrPageLoadTimeout, render.ErrSelectorTimeout:
+			// the body tells a page that never loaded from one that never rendered
 			w.WriteHeader(http.StatusGatewayTimeout)
-		} else {
+			fmt.Fprint(w, err)
+			log.WithError(err).Warnf("render timed out")
+		case render.ErrQueueFull, render.ErrQueueTimeout, render.ErrChromeTerminated:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
 			w.WriteHeader(http.StatusInternalServerError)
 			log.WithError(err).Errorf("error rendering")
 		}
 		return
 	}
 
+	if stale(res) {
+		w.Header().Set(cacheStatusHeader, "stale")
+	} else {
+		w.Header().Set(cacheStatusHeader, "fresh")
+	}
+	for name, values := range res.Headers {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
 	if res.Status != http.StatusOK {
 		w.WriteHeader(res.Status)
 		return
@@ -65,6 +385,17 @@ func writeResult(res *render.Result, err error, w http.ResponseWriter) {
 	if res.Etag != "" {
 		w.Header().Add("Etag", res.Etag)
 	}
+	// cached pages may be returned compressed depending on Accept-Encoding
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(res.GzipHTML) > 0 {
+		// net/http would sniff the compressed body as application/x-gzip
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(res.GzipHTML)
+		return
+	}
 	if res.HTML != "" {
 		fmt.Fprint(w, res.HTML)
 	}
@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/brycekahle/prerender/render"
@@ -10,7 +12,7 @@ import (
 )
 
 type redisCache struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
 // Cache caches prerendering results for quick retrieval later
@@ -19,54 +21,141 @@ type Cache interface {
 	Save(*render.Result, time.Duration) error
 }
 
-// NewCache creates a new caching layer using Redis as backend
-func NewCache(client *redis.Client) Cache {
+// NewCache creates a new caching layer using Redis as backend.
+// The client may be a single node, Sentinel or Cluster client.
+func NewCache(client redis.UniversalClient) Cache {
 	return &redisCache{client}
 }
 
-func (c *redisCache) checkEtag(r *http.Request) (bool, error) {
+// redisKey returns the key of the hash holding a result. The result's key is
+// a hash tag, so all keys of a result are stored in the same Redis Cluster slot.
+func redisKey(key string) string {
+	return "{" + key + "}"
+}
+
+func (c *redisCache) checkEtag(r *http.Request) (*render.Result, error) {
 	if etag := r.Header.Get("If-None-Match"); etag != "" {
-		redisEtag, err := c.client.HGet(r.URL.Path, "Etag").Result()
-		if err != nil && err != redis.Nil {
-			return false, errors.Wrap(err, "getting cached etag failed")
+		key := requestKey(r)
+		values, err := c.client.HMGet(redisKey(key), "Etag", "fresh").Result()
+		if err == nil && values[0] == nil {
+			// results saved before keys were hash tagged
+			values, err = c.client.HMGet(key, "Etag", "fresh").Result()
 		}
-		return etag == redisEtag, nil
+		if err != nil {
+			return nil, errors.Wrap(err, "getting cached etag failed")
+		}
+		redisEtag, _ := values[0].(string)
+		fresh, _ := values[1].(string)
+		return notModified(r, redisEtag, parseFresh(fresh)), nil
 	}
-	return false, nil
+	return nil, nil
 }
 
-func (c *redisCache) Check(r *http.Request) (*render.Result, error) {
-	matches, err := c.checkEtag(r)
+// parseFresh reads the freshness deadline stored in unix milliseconds.
+// Entries without one are fresh until they expire.
+func parseFresh(v string) time.Time {
+	ms, err := strconv.ParseInt(v, 10, 64)
 	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func (c *redisCache) Check(r *http.Request) (*render.Result, error) {
+	res, err := c.checkEtag(r)
+	if err != nil || res != nil {
+		return res, err
+	}
+
+	e, err := c.entry(r)
+	if err != nil || e == nil {
 		return nil, err
 	}
-	if matches {
-		return &render.Result{Status: http.StatusNotModified}, nil
+	return e.result(r)
+}
+
+// entry loads the result requested by r along with its expiry,
+// or returns nil if it is not cached
+func (c *redisCache) entry(r *http.Request) (*entry, error) {
+	key := requestKey(r)
+	e, err := c.load(r, redisKey(key))
+	if err != nil || e != nil {
+		return e, err
 	}
+	// results saved before keys were hash tagged are read until they expire
+	return c.load(r, key)
+}
 
-	data, err := c.client.HGetAll(r.URL.Path).Result()
-	if err != nil {
+// load reads the result requested by r from the hash stored at key
+func (c *redisCache) load(r *http.Request, key string) (*entry, error) {
+	pipe := c.client.Pipeline()
+	fields := pipe.HGetAll(key)
+	ttl := pipe.PTTL(key)
+	if _, err := pipe.Exec(); err != nil {
 		return nil, errors.Wrap(err, "getting cached data failed")
 	}
+	data := fields.Val()
 	html, ok := data["html"]
 	if !ok {
 		return nil, nil
 	}
 
-	res := render.Result{
+	e := &entry{
+		URL:             r.URL.Path,
+		Device:          render.Device(r.Header.Get(DeviceHeader)),
+		ReturnRedirects: returnsRedirects(r),
+		Etag:            data["Etag"],
+		FreshUntil:      parseFresh(data["fresh"]),
+		HTML:            []byte(html),
+		// entries saved before compression was added are not encoded
+		Gzip: data["encoding"] == "gzip",
+		// entries saved before statuses were stored are rendered pages
 		Status: http.StatusOK,
-		HTML:   html,
-		Etag:   data["Etag"],
 	}
-	return &res, nil
+	if v, ok := data["status"]; ok {
+		status, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding cached status failed")
+		}
+		e.Status = status
+	}
+	if headers, ok := data["headers"]; ok {
+		if err := json.Unmarshal([]byte(headers), &e.Headers); err != nil {
+			return nil, errors.Wrap(err, "decoding cached headers failed")
+		}
+	}
+	if d := ttl.Val(); d > 0 {
+		e.Expires = time.Now().Add(d)
+	}
+	return e, nil
 }
 
 func (c *redisCache) Save(res *render.Result, ttl time.Duration) error {
+	e, err := newEntry(res, ttl)
+	if err != nil {
+		return err
+	}
+
+	key := redisKey(e.key())
 	tx := c.client.TxPipeline()
-	tx.HSet(res.URL, "Etag", res.Etag)
-	tx.HSet(res.URL, "html", res.HTML)
-	tx.PExpire(res.URL, ttl)
+	// fields of an earlier render must not outlive it
+	tx.Del(key)
+	tx.HSet(key, "Etag", e.Etag)
+	tx.HSet(key, "html", e.HTML)
+	tx.HSet(key, "encoding", "gzip")
+	tx.HSet(key, "status", strconv.Itoa(e.Status))
+	if len(e.Headers) > 0 {
+		headers, err := json.Marshal(e.Headers)
+		if err != nil {
+			return errors.Wrap(err, "encoding headers failed")
+		}
+		tx.HSet(key, "headers", string(headers))
+	}
+	if !e.FreshUntil.IsZero() {
+		tx.HSet(key, "fresh", strconv.FormatInt(e.FreshUntil.UnixNano()/int64(time.Millisecond), 10))
+	}
+	tx.PExpire(key, ttl)
 
-	_, err := tx.Exec()
+	_, err = tx.Exec()
 	return err
 }
@@ -35,7 +35,7 @@ func TestMain(m *testing.M) {
 
 func TestEtagMatch(t *testing.T) {
 	s.FlushAll()
-	s.HSet("https://netlify.com/", "Etag", "etagetag")
+	s.HSet(redisKey("https://netlify.com/"), "Etag", "etagetag")
 	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
 	// TODO having to do this kinda sucks
 	req.URL.Path = req.URL.Path[1:]
@@ -47,8 +47,8 @@ func TestEtagMatch(t *testing.T) {
 
 func TestEtagMismatch(t *testing.T) {
 	s.FlushAll()
-	s.HSet("https://netlify.com/", "Etag", "etagetag")
-	s.HSet("https://netlify.com/", "html", "<html></html>")
+	s.HSet(redisKey("https://netlify.com/"), "Etag", "etagetag")
+	s.HSet(redisKey("https://netlify.com/"), "html", "<html></html>")
 	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
 	// TODO having to do this kinda sucks
 	req.URL.Path = req.URL.Path[1:]
@@ -79,7 +79,7 @@ func TestSave(t *testing.T) {
 		Etag: "etagetag",
 	}, 24*time.Hour)
 	require.NoError(t, err)
-	etag := s.HGet("https://netlify.com/", "Etag")
+	etag := s.HGet(redisKey("https://netlify.com/"), "Etag")
 	assert.Equal(t, "etagetag", etag)
 }
 
@@ -91,15 +91,164 @@ func TestSaveExpire(t *testing.T) {
 		Etag: "etagetag",
 	}, 24*time.Hour)
 	require.NoError(t, err)
-	etag := s.HGet("https://netlify.com/", "Etag")
+	etag := s.HGet(redisKey("https://netlify.com/"), "Etag")
 	assert.Equal(t, "etagetag", etag)
 	s.FastForward(24 * time.Hour)
-	etag = s.HGet("https://netlify.com/", "Etag")
+	etag = s.HGet(redisKey("https://netlify.com/"), "Etag")
 	assert.Empty(t, etag)
 }
 
+func TestSaveHeaders(t *testing.T) {
+	s.FlushAll()
+	headers := http.Header{
+		"X-Robots-Tag": {"noindex"},
+		"Link":         {"<https://netlify.com/>; rel=canonical", "</app.css>; rel=preload"},
+	}
+	err := client.Save(&render.Result{
+		URL:     "https://netlify.com/",
+		HTML:    "<html></html>",
+		Etag:    "etagetag",
+		Headers: headers,
+	}, 24*time.Hour)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	// TODO having to do this kinda sucks
+	req.URL.Path = req.URL.Path[1:]
+	res, err := client.Check(req)
+	require.NoError(t, err)
+	assert.Equal(t, headers, res.Headers)
+
+	// a later render without headers drops the stored ones
+	err = client.Save(&render.Result{URL: "https://netlify.com/", HTML: "<html></html>"}, 24*time.Hour)
+	require.NoError(t, err)
+	res, err = client.Check(req)
+	require.NoError(t, err)
+	assert.Empty(t, res.Headers)
+}
+
+func TestSaveFreshUntil(t *testing.T) {
+	s.FlushAll()
+	fresh := time.Unix(1506859200, 0)
+	err := client.Save(&render.Result{
+		URL:        "https://netlify.com/",
+		HTML:       "<html></html>",
+		Etag:       "etagetag",
+		FreshUntil: fresh,
+	}, 24*time.Hour)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	// TODO having to do this kinda sucks
+	req.URL.Path = req.URL.Path[1:]
+	res, err := client.Check(req)
+	require.NoError(t, err)
+	assert.True(t, fresh.Equal(res.FreshUntil))
+
+	req.Header.Add("If-None-Match", "etagetag")
+	res, err = client.Check(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, res.Status)
+	assert.True(t, fresh.Equal(res.FreshUntil))
+}
+
+func TestSaveStatus(t *testing.T) {
+	s.FlushAll()
+	err := client.Save(&render.Result{
+		URL:     "https://netlify.com/",
+		Status:  http.StatusMovedPermanently,
+		Headers: http.Header{"Location": {"https://www.netlify.com/"}},
+	}, time.Hour)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	// TODO having to do this kinda sucks
+	req.URL.Path = req.URL.Path[1:]
+	res, err := client.Check(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMovedPermanently, res.Status)
+	assert.Equal(t, "https://www.netlify.com/", res.Headers.Get("Location"))
+	assert.Empty(t, res.HTML)
+}
+
+func TestCheckWithoutStatus(t *testing.T) {
+	s.FlushAll()
+	s.HSet(redisKey("https://netlify.com/"), "html", "<html></html>")
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	// TODO having to do this kinda sucks
+	req.URL.Path = req.URL.Path[1:]
+	res, err := client.Check(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.Equal(t, "<html></html>", res.HTML)
+}
+
+func TestCheckLegacyKey(t *testing.T) {
+	s.FlushAll()
+	// entries saved before keys were hash tagged
+	s.HSet("https://netlify.com/", "html", "<html></html>")
+	s.HSet("https://netlify.com/", "Etag", "etagetag")
+	res := checkURL(t, client, "https://netlify.com/")
+	require.NotNil(t, res)
+	assert.Equal(t, "<html></html>", res.HTML)
+
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	// TODO having to do this kinda sucks
+	req.URL.Path = req.URL.Path[1:]
+	req.Header.Add("If-None-Match", "etagetag")
+	res, err := client.Check(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, res.Status)
+
+	// a new render replaces the legacy entry
+	require.NoError(t, client.Save(&render.Result{URL: "https://netlify.com/", HTML: "<html>new</html>"}, time.Hour))
+	assert.Equal(t, "<html>new</html>", checkURL(t, client, "https://netlify.com/").HTML)
+}
+
+func TestSaveGzip(t *testing.T) {
+	s.FlushAll()
+	err := client.Save(&render.Result{
+		URL:  "https://netlify.com/",
+		HTML: "<html></html>",
+		Etag: "etagetag",
+	}, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", s.HGet(redisKey("https://netlify.com/"), "encoding"))
+	assert.NotEqual(t, "<html></html>", s.HGet(redisKey("https://netlify.com/"), "html"))
+
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	// TODO having to do this kinda sucks
+	req.URL.Path = req.URL.Path[1:]
+	res, err := client.Check(req)
+	require.NoError(t, err)
+	assert.Equal(t, "<html></html>", res.HTML)
+	assert.Empty(t, res.GzipHTML)
+
+	req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+	res, err = client.Check(req)
+	require.NoError(t, err)
+	assert.Empty(t, res.HTML)
+	html, err := gunzip(res.GzipHTML)
+	require.NoError(t, err)
+	assert.Equal(t, "<html></html>", html)
+}
+
+func TestRedisKeys(t *testing.T) {
+	s.FlushAll()
+	locker := client.(Locker)
+	require.NoError(t, client.Save(&render.Result{URL: "https://netlify.com/", HTML: "<html></html>"}, time.Hour))
+	unlock, err := locker.Lock("https://netlify.com/", time.Minute)
+	require.NoError(t, err)
+	defer unlock()
+
+	// both keys hash to the slot of the URL in Redis Cluster
+	assert.Equal(t, []string{"{https://netlify.com/}", "{https://netlify.com/}:lock"}, s.Keys())
+}
+
 func TestCheckError(t *testing.T) {
 	s.Close()
+	// later tests need the server
+	defer s.Restart()
 	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
 	// TODO having to do this kinda sucks
 	req.URL.Path = req.URL.Path[1:]
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+)
+
+// DeviceHeader carries the device class of a request.
+// Results are cached separately for each device class.
+const DeviceHeader = "X-Prerender-Device"
+
+// FollowRedirectsHeader tells whether a request follows redirects from the
+// origin. Results of requests returning redirects are cached separately.
+const FollowRedirectsHeader = "X-Prerender-Follow-Redirects"
+
+// Key identifies the result of the URL rendered for the device class,
+// following redirects from the origin or returning them. Desktop results
+// following redirects are keyed by the URL alone.
+func Key(url string, device render.Device, returnRedirects bool) string {
+	key := url
+	// normalized URLs do not contain spaces
+	if device != "" && device != render.DeviceDesktop {
+		key += " " + string(device)
+	}
+	if returnRedirects {
+		key += " redirects"
+	}
+	return key
+}
+
+// requestKey returns the key of the result requested by r
+func requestKey(r *http.Request) string {
+	return Key(r.URL.Path, render.Device(r.Header.Get(DeviceHeader)), returnsRedirects(r))
+}
+
+// returnsRedirects tells whether r asks for redirects to be returned
+func returnsRedirects(r *http.Request) bool {
+	follow, err := strconv.ParseBool(r.Header.Get(FollowRedirectsHeader))
+	return err == nil && !follow
+}
+
+// entry is a result as kept by a cache backend
+type entry struct {
+	URL             string        `json:"url"`
+	Device          render.Device `json:"device,omitempty"`
+	ReturnRedirects bool          `json:"return_redirects,omitempty"`
+	Status          int           `json:"status"`
+	Etag            string        `json:"etag,omitempty"`
+	Headers         http.Header   `json:"headers,omitempty"`
+	FreshUntil      time.Time     `json:"fresh_until,omitempty"`
+	Expires         time.Time     `json:"expires"`
+	// HTML is gzip compressed unless the entry was stored before
+	// compression was added
+	HTML []byte `json:"-"`
+	Gzip bool   `json:"gzip"`
+}
+
+func newEntry(res *render.Result, ttl time.Duration) (*entry, error) {
+	html, err := gzipHTML(res.HTML)
+	if err != nil {
+		return nil, err
+	}
+	status := res.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &entry{
+		URL:             res.URL,
+		Device:          res.Device,
+		ReturnRedirects: res.ReturnRedirects,
+		Status:          status,
+		Etag:            res.Etag,
+		Headers:         res.Headers,
+		FreshUntil:      res.FreshUntil,
+		Expires:         time.Now().Add(ttl),
+		HTML:            html,
+		Gzip:            true,
+	}, nil
+}
+
+func (e *entry) key() string {
+	return Key(e.URL, e.Device, e.ReturnRedirects)
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// size approximates the memory used by the entry
+func (e *entry) size() int64 {
+	n := len(e.URL) + len(e.Etag) + len(e.HTML)
+	for name, values := range e.Headers {
+		n += len(name)
+		for _, v := range values {
+			n += len(v)
+		}
+	}
+	return int64(n)
+}
+
+// notModified returns a 304 result if the request's If-None-Match header matches the etag
+func notModified(r *http.Request, etag string, fresh time.Time) *render.Result {
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		return &render.Result{Status: http.StatusNotModified, FreshUntil: fresh}
+	}
+	return nil
+}
+
+// result returns the entry as the response to r, which is a 304
+// if the client has it already. Compressed HTML is only decompressed
+// if the client does not accept gzip.
+func (e *entry) result(r *http.Request) (*render.Result, error) {
+	if res := notModified(r, e.Etag, e.FreshUntil); res != nil {
+		return res, nil
+	}
+
+	res := &render.Result{
+		URL:             e.URL,
+		Device:          e.Device,
+		ReturnRedirects: e.ReturnRedirects,
+		Status:          e.Status,
+		Etag:            e.Etag,
+		Headers:         e.Headers,
+		FreshUntil:      e.FreshUntil,
+	}
+	switch {
+	case !e.Gzip:
+		res.HTML = string(e.HTML)
+	case acceptsGzip(r):
+		res.GzipHTML = e.HTML
+	default:
+		html, err := gunzip(e.HTML)
+		if err != nil {
+			return nil, err
+		}
+		res.HTML = html
+	}
+	return res, nil
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+	"github.com/pkg/errors"
+)
+
+type fileCache struct {
+	dir string
+}
+
+// NewFileCache creates a caching layer storing results in dir. Each result
+// is stored as a file named after the hash of its URL holding the compressed
+// HTML and a .json file holding its metadata.
+func NewFileCache(dir string) (Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "creating cache directory failed")
+	}
+	return &fileCache{dir}, nil
+}
+
+// paths returns the HTML and metadata file of the result key
+func (c *fileCache) paths(key string) (string, string) {
+	hash := sha256.Sum256([]byte(key))
+	name := filepath.Join(c.dir, hex.EncodeToString(hash[:]))
+	return name + ".html.gz", name + ".json"
+}
+
+func (c *fileCache) Check(r *http.Request) (*render.Result, error) {
+	htmlPath, metaPath := c.paths(requestKey(r))
+	meta, err := ioutil.ReadFile(metaPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading cached metadata failed")
+	}
+
+	e := &entry{}
+	if err := json.Unmarshal(meta, e); err != nil {
+		return nil, errors.Wrap(err, "decoding cached metadata failed")
+	}
+	// hash collisions are astronomically unlikely, but cheap to rule out
+	if e.key() != requestKey(r) {
+		return nil, nil
+	}
+	if e.expired(time.Now()) {
+		os.Remove(metaPath)
+		os.Remove(htmlPath)
+		return nil, nil
+	}
+	if res := notModified(r, e.Etag, e.FreshUntil); res != nil {
+		return res, nil
+	}
+
+	if e.HTML, err = ioutil.ReadFile(htmlPath); err != nil {
+		// the entry is being replaced
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "reading cached html failed")
+	}
+	return e.result(r)
+}
+
+func (c *fileCache) Save(res *render.Result, ttl time.Duration) error {
+	e, err := newEntry(res, ttl)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encoding metadata failed")
+	}
+
+	// the metadata is written last, an entry without it is incomplete
+	htmlPath, metaPath := c.paths(e.key())
+	if err := c.writeFile(htmlPath, e.HTML); err != nil {
+		return errors.Wrap(err, "writing cached html failed")
+	}
+	if err := c.writeFile(metaPath, meta); err != nil {
+		return errors.Wrap(err, "writing cached metadata failed")
+	}
+	return nil
+}
+
+// writeFile replaces the file atomically so readers never see a partial file
+func (c *fileCache) writeFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
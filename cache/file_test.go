@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempFileCache(t *testing.T) (Cache, string) {
+	dir, err := ioutil.TempDir("", "prerender-cache-test-")
+	require.NoError(t, err)
+	c, err := NewFileCache(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	return c, dir
+}
+
+func TestFileCache(t *testing.T) {
+	c, dir := tempFileCache(t)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, checkURL(t, c, "https://netlify.com/"))
+
+	err := c.Save(&render.Result{
+		URL:     "https://netlify.com/",
+		Status:  http.StatusNotFound,
+		HTML:    "<html></html>",
+		Etag:    "etagetag",
+		Headers: http.Header{"X-Robots-Tag": {"noindex"}},
+	}, time.Hour)
+	require.NoError(t, err)
+
+	res := checkURL(t, c, "https://netlify.com/")
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusNotFound, res.Status)
+	assert.Equal(t, "<html></html>", res.HTML)
+	assert.Equal(t, "etagetag", res.Etag)
+	assert.Equal(t, "noindex", res.Headers.Get("X-Robots-Tag"))
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestFileCacheExpire(t *testing.T) {
+	c, dir := tempFileCache(t)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, c.Save(&render.Result{URL: "https://netlify.com/", HTML: "<html></html>"}, time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, checkURL(t, c, "https://netlify.com/"))
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
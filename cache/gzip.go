@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+func gzipHTML(html string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(html)); err != nil {
+		return nil, errors.Wrap(err, "compressing html failed")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "compressing html failed")
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzip(b []byte) (string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return "", errors.Wrap(err, "decompressing cached html failed")
+	}
+	html, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", errors.Wrap(err, "decompressing cached html failed")
+	}
+	return string(html), nil
+}
+
+// acceptsGzip reports whether the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(header, ",") {
+			parts := strings.Split(coding, ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			q := 1.0
+			for _, param := range parts[1:] {
+				if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+					q, _ = strconv.ParseFloat(kv[1], 64)
+				}
+			}
+			if q > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
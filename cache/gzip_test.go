@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"deflate, GZIP":        true,
+		"br;q=1.0, gzip;q=0.5": true,
+		"gzip;q=0":             false,
+		"identity":             false,
+		"*":                    true,
+	}
+	for header, accepts := range cases {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		if header != "" {
+			req.Header.Set("Accept-Encoding", header)
+		}
+		assert.Equal(t, accepts, acceptsGzip(req), header)
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned when another API instance holds the render lock for a URL
+var ErrLocked = errors.New("render locked by another instance")
+
+// Locker is implemented by caches shared between API instances,
+// which can ensure only one of them renders a URL at a time
+type Locker interface {
+	// Lock acquires the render lock for the result key or returns ErrLocked.
+	// The lock expires after ttl in case its owner crashes,
+	// the returned function releases it.
+	Lock(key string, ttl time.Duration) (func() error, error)
+}
+
+// unlockScript deletes the lock only if it is still owned by the
+// token, it may have expired and been acquired by another instance
+const unlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`
+
+func (c *redisCache) Lock(key string, ttl time.Duration) (func() error, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "generating lock token failed")
+	}
+	lockKey, token := redisKey(key)+":lock", hex.EncodeToString(b)
+
+	ok, err := c.client.SetNX(lockKey, token, ttl).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "acquiring render lock failed")
+	}
+	if !ok {
+		return nil, ErrLocked
+	}
+	return func() error {
+		err := c.client.Eval(unlockScript, []string{lockKey}, token).Err()
+		return errors.Wrap(err, "releasing render lock failed")
+	}, nil
+}
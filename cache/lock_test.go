@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	s.FlushAll()
+	locker := client.(Locker)
+
+	unlock, err := locker.Lock("https://netlify.com/", time.Minute)
+	require.NoError(t, err)
+
+	_, err = locker.Lock("https://netlify.com/", time.Minute)
+	assert.Equal(t, ErrLocked, err)
+
+	require.NoError(t, unlock())
+	unlock, err = locker.Lock("https://netlify.com/", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, unlock())
+}
+
+func TestLockExpire(t *testing.T) {
+	s.FlushAll()
+	locker := client.(Locker)
+
+	unlock, err := locker.Lock("https://netlify.com/", time.Minute)
+	require.NoError(t, err)
+
+	// the owner crashed, another instance takes over
+	s.FastForward(time.Minute)
+	unlockOther, err := locker.Lock("https://netlify.com/", time.Minute)
+	require.NoError(t, err)
+
+	// the late owner must not release the other instance's lock
+	require.NoError(t, unlock())
+	_, err = locker.Lock("https://netlify.com/", time.Minute)
+	assert.Equal(t, ErrLocked, err)
+	require.NoError(t, unlockOther())
+}
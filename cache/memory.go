@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+)
+
+// DefaultMemoryBudget is the default size of the in-memory cache in bytes
+const DefaultMemoryBudget = 256 << 20
+
+type memoryCache struct {
+	budget int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	// recent orders the entries from most to least recently used
+	recent *list.List
+}
+
+// NewMemoryCache creates a caching layer keeping results in process memory.
+// The least recently used results are evicted once they take up more than
+// budget bytes.
+func NewMemoryCache(budget int64) Cache {
+	return &memoryCache{
+		budget:  budget,
+		entries: map[string]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+func (c *memoryCache) Check(r *http.Request) (*render.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[requestKey(r)]
+	if !ok {
+		return nil, nil
+	}
+	e := el.Value.(*entry)
+	if e.expired(time.Now()) {
+		c.remove(el)
+		return nil, nil
+	}
+	c.recent.MoveToFront(el)
+	return e.result(r)
+}
+
+func (c *memoryCache) Save(res *render.Result, ttl time.Duration) error {
+	e, err := newEntry(res, ttl)
+	if err != nil {
+		return err
+	}
+	c.put(e)
+	return nil
+}
+
+func (c *memoryCache) put(e *entry) {
+	// an entry larger than the whole budget would evict everything else
+	if e.size() > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key()]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key()] = c.recent.PushFront(e)
+	c.size += e.size()
+	for c.size > c.budget {
+		c.remove(c.recent.Back())
+	}
+}
+
+func (c *memoryCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+// remove deletes the entry. c.mu must be held.
+func (c *memoryCache) remove(el *list.Element) {
+	e := c.recent.Remove(el).(*entry)
+	delete(c.entries, e.key())
+	c.size -= e.size()
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checkURL(t *testing.T, c Cache, url string) *render.Result {
+	req := httptest.NewRequest("GET", "http://example.com/"+url, nil)
+	// TODO having to do this kinda sucks
+	req.URL.Path = req.URL.Path[1:]
+	res, err := c.Check(req)
+	require.NoError(t, err)
+	return res
+}
+
+func TestMemoryCache(t *testing.T) {
+	c := NewMemoryCache(DefaultMemoryBudget)
+	assert.Nil(t, checkURL(t, c, "https://netlify.com/"))
+
+	err := c.Save(&render.Result{
+		URL:     "https://netlify.com/",
+		Status:  http.StatusOK,
+		HTML:    "<html></html>",
+		Etag:    "etagetag",
+		Headers: http.Header{"X-Robots-Tag": {"noindex"}},
+	}, time.Hour)
+	require.NoError(t, err)
+
+	res := checkURL(t, c, "https://netlify.com/")
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.Equal(t, "<html></html>", res.HTML)
+	assert.Equal(t, "etagetag", res.Etag)
+	assert.Equal(t, "noindex", res.Headers.Get("X-Robots-Tag"))
+
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.URL.Path = req.URL.Path[1:]
+	req.Header.Set("If-None-Match", "etagetag")
+	res, err = c.Check(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, res.Status)
+}
+
+func TestMemoryCacheExpire(t *testing.T) {
+	c := NewMemoryCache(DefaultMemoryBudget)
+	require.NoError(t, c.Save(&render.Result{URL: "https://netlify.com/", HTML: "<html></html>"}, time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, checkURL(t, c, "https://netlify.com/"))
+	assert.Empty(t, c.(*memoryCache).entries)
+}
+
+func TestMemoryCacheEvict(t *testing.T) {
+	save := func(c Cache, url string) {
+		require.NoError(t, c.Save(&render.Result{URL: url, HTML: "<html></html>"}, time.Hour))
+	}
+	probe := NewMemoryCache(DefaultMemoryBudget).(*memoryCache)
+	save(probe, "https://a.com/")
+	// room for two entries
+	c := NewMemoryCache(2 * probe.size)
+
+	save(c, "https://a.com/")
+	save(c, "https://b.com/")
+	assert.NotNil(t, checkURL(t, c, "https://a.com/"))
+	save(c, "https://c.com/")
+
+	assert.NotNil(t, checkURL(t, c, "https://a.com/"))
+	assert.Nil(t, checkURL(t, c, "https://b.com/"))
+	assert.NotNil(t, checkURL(t, c, "https://c.com/"))
+	assert.Equal(t, 2*probe.size, c.(*memoryCache).size)
+}
+
+func TestMemoryCacheDevice(t *testing.T) {
+	c := NewMemoryCache(DefaultMemoryBudget)
+	err := c.Save(&render.Result{URL: "https://netlify.com/", Device: render.DeviceMobile, Status: http.StatusOK, HTML: "mobile"}, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, checkURL(t, c, "https://netlify.com/"))
+
+	err = c.Save(&render.Result{URL: "https://netlify.com/", Status: http.StatusOK, HTML: "desktop"}, time.Hour)
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.URL.Path = req.URL.Path[1:]
+	req.Header.Set(DeviceHeader, "mobile")
+	res, err := c.Check(req)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, "mobile", res.HTML)
+	assert.Equal(t, render.DeviceMobile, res.Device)
+
+	req.Header.Set(DeviceHeader, "desktop")
+	res, err = c.Check(req)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, "desktop", res.HTML)
+	assert.Equal(t, "https://netlify.com/ mobile", Key("https://netlify.com/", render.DeviceMobile, false))
+}
+
+func TestMemoryCacheReturnRedirects(t *testing.T) {
+	c := NewMemoryCache(DefaultMemoryBudget)
+	err := c.Save(&render.Result{
+		URL:             "https://netlify.com/",
+		ReturnRedirects: true,
+		Status:          http.StatusMovedPermanently,
+		Headers:         http.Header{"Location": {"https://www.netlify.com/"}},
+	}, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, checkURL(t, c, "https://netlify.com/"))
+
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.URL.Path = req.URL.Path[1:]
+	req.Header.Set(FollowRedirectsHeader, "false")
+	res, err := c.Check(req)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusMovedPermanently, res.Status)
+	assert.True(t, res.ReturnRedirects)
+	assert.Equal(t, "https://netlify.com/ tablet redirects", Key("https://netlify.com/", render.DeviceTablet, true))
+}
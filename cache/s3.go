@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+	"github.com/pkg/errors"
+)
+
+// S3Config locates the bucket of an S3 compatible object store
+type S3Config struct {
+	// Endpoint is the base URL of the object store, e.g. https://s3.us-east-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+// s3RequestTimeout bounds each request to the object store
+const s3RequestTimeout = 10 * time.Second
+
+type s3Cache struct {
+	config S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3Cache creates a caching layer storing results as objects in an S3
+// compatible bucket. The etag and expiry of a result are stored as object
+// metadata. Expired objects are not deleted, the bucket should have a
+// lifecycle rule removing them.
+func NewS3Cache(config S3Config) Cache {
+	config.Endpoint = strings.TrimRight(config.Endpoint, "/")
+	return &s3Cache{
+		config: config,
+		client: &http.Client{Timeout: s3RequestTimeout},
+		now:    time.Now,
+	}
+}
+
+// objectURL returns the path-style URL of the object holding the result
+func (c *s3Cache) objectURL(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return c.config.Endpoint + "/" + c.config.Bucket + "/" + c.config.Prefix + hex.EncodeToString(hash[:])
+}
+
+func (c *s3Cache) Check(r *http.Request) (*render.Result, error) {
+	// the metadata is enough to tell the client it has the page already
+	if r.Header.Get("If-None-Match") != "" {
+		resp, err := c.do("HEAD", c.objectURL(requestKey(r)), nil, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting cached etag failed")
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("getting cached etag failed: %s", resp.Status)
+		}
+		if !c.expired(resp.Header) {
+			fresh := parseFresh(resp.Header.Get("X-Amz-Meta-Fresh"))
+			if res := notModified(r, resp.Header.Get("X-Amz-Meta-Etag"), fresh); res != nil {
+				return res, nil
+			}
+		}
+	}
+
+	resp, err := c.do("GET", c.objectURL(requestKey(r)), nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cached data failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("getting cached data failed: %s", resp.Status)
+	}
+	if c.expired(resp.Header) {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cached data failed")
+	}
+
+	// the object is the metadata as a line of JSON followed by the HTML
+	i := bytes.IndexByte(body, '\n')
+	if i < 0 {
+		return nil, errors.New("decoding cached data failed: missing metadata")
+	}
+	e := &entry{}
+	if err := json.Unmarshal(body[:i], e); err != nil {
+		return nil, errors.Wrap(err, "decoding cached metadata failed")
+	}
+	// object names are hashes of the key, the metadata holds the key itself
+	if e.key() != requestKey(r) {
+		return nil, nil
+	}
+	e.HTML = body[i+1:]
+	return e.result(r)
+}
+
+func (c *s3Cache) expired(header http.Header) bool {
+	expires, err := strconv.ParseInt(header.Get("X-Amz-Meta-Expires"), 10, 64)
+	return err != nil || !c.now().Before(time.Unix(expires, 0))
+}
+
+func (c *s3Cache) Save(res *render.Result, ttl time.Duration) error {
+	e, err := newEntry(res, ttl)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encoding metadata failed")
+	}
+	body := append(append(meta, '\n'), e.HTML...)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("X-Amz-Meta-Etag", e.Etag)
+	header.Set("X-Amz-Meta-Expires", strconv.FormatInt(e.Expires.Unix(), 10))
+	if !e.FreshUntil.IsZero() {
+		header.Set("X-Amz-Meta-Fresh", strconv.FormatInt(e.FreshUntil.UnixNano()/int64(time.Millisecond), 10))
+	}
+
+	resp, err := c.do("PUT", c.objectURL(e.key()), header, body)
+	if err != nil {
+		return errors.Wrap(err, "storing cached data failed")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("storing cached data failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// do sends a signed request to the object store
+func (c *s3Cache) do(method, url string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	c.sign(req, body, c.now())
+	return c.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 authorization header to the request.
+// All headers set on the request are signed.
+func (c *s3Cache) sign(req *http.Request, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := amzDate[:8]
+	scope := date + "/" + c.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.config.SecretKey), date)
+	key = hmacSHA256(key, c.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// DefaultLocalTTL bounds how long a result is kept in the local tier,
+// in case an invalidation message is lost
+const DefaultLocalTTL = time.Minute
+
+// invalidateChannel is the Redis channel the keys of
+// replaced results are published to
+const invalidateChannel = "prerender:invalidate"
+
+type tieredCache struct {
+	local    *memoryCache
+	shared   *redisCache
+	localTTL time.Duration
+	publish  func(url string) error
+}
+
+// NewTieredCache creates a caching layer keeping recently used results in
+// process memory in front of Redis. Results replaced by any API instance
+// are removed from the memory of all instances. The returned function
+// stops listening for replaced results.
+func NewTieredCache(client redis.UniversalClient, budget int64, localTTL time.Duration) (Cache, func() error) {
+	local, shared := NewMemoryCache(budget).(*memoryCache), NewCache(client).(*redisCache)
+	c := newTieredCache(local, shared, localTTL, func(url string) error {
+		return client.Publish(invalidateChannel, url).Err()
+	})
+
+	sub := client.Subscribe(invalidateChannel)
+	go func() {
+		for msg := range sub.Channel() {
+			c.invalidate(msg.Payload)
+		}
+	}()
+	return c, sub.Close
+}
+
+func newTieredCache(local *memoryCache, shared *redisCache, localTTL time.Duration, publish func(string) error) *tieredCache {
+	return &tieredCache{
+		local:    local,
+		shared:   shared,
+		localTTL: localTTL,
+		publish:  publish,
+	}
+}
+
+func (c *tieredCache) Check(r *http.Request) (*render.Result, error) {
+	res, err := c.local.Check(r)
+	if err != nil || res != nil {
+		return res, err
+	}
+
+	e, err := c.shared.entry(r)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	// the local copy must not outlive the shared entry
+	expires := time.Now().Add(c.localTTL)
+	if e.Expires.IsZero() || e.Expires.After(expires) {
+		e.Expires = expires
+	}
+	c.local.put(e)
+	return e.result(r)
+}
+
+func (c *tieredCache) Save(res *render.Result, ttl time.Duration) error {
+	key := Key(res.URL, res.Device, res.ReturnRedirects)
+	c.local.delete(key)
+	if err := c.shared.Save(res, ttl); err != nil {
+		return err
+	}
+	return errors.Wrap(c.publish(key), "publishing cache invalidation failed")
+}
+
+func (c *tieredCache) Lock(key string, ttl time.Duration) (func() error, error) {
+	return c.shared.Lock(key, ttl)
+}
+
+// invalidate removes the result from the local tier of this instance
+func (c *tieredCache) invalidate(key string) {
+	c.local.delete(key)
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// miniredis does not support pub/sub, messages are delivered by calling invalidate
+func newTestTieredCache(published *[]string) *tieredCache {
+	return newTieredCache(NewMemoryCache(DefaultMemoryBudget).(*memoryCache), client.(*redisCache), time.Minute, func(url string) error {
+		*published = append(*published, url)
+		return nil
+	})
+}
+
+func TestTieredCacheReadThrough(t *testing.T) {
+	s.FlushAll()
+	var published []string
+	c := newTestTieredCache(&published)
+
+	require.NoError(t, client.Save(&render.Result{URL: "https://netlify.com/", HTML: "<html></html>", Etag: "etagetag"}, time.Hour))
+	res := checkURL(t, c, "https://netlify.com/")
+	require.NotNil(t, res)
+	assert.Equal(t, "<html></html>", res.HTML)
+
+	// later reads are served from memory
+	s.FlushAll()
+	res = checkURL(t, c, "https://netlify.com/")
+	require.NotNil(t, res)
+	assert.Equal(t, "<html></html>", res.HTML)
+
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.URL.Path = req.URL.Path[1:]
+	req.Header.Set("If-None-Match", "etagetag")
+	res, err := c.Check(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, res.Status)
+}
+
+func TestTieredCacheLegacyEntry(t *testing.T) {
+	s.FlushAll()
+	var published []string
+	c := newTestTieredCache(&published)
+
+	s.HSet(redisKey("https://netlify.com/"), "html", "<html></html>")
+	for i := 0; i < 2; i++ {
+		res := checkURL(t, c, "https://netlify.com/")
+		require.NotNil(t, res)
+		assert.Equal(t, "<html></html>", res.HTML)
+	}
+}
+
+func TestTieredCacheInvalidate(t *testing.T) {
+	s.FlushAll()
+	var published []string
+	c := newTestTieredCache(&published)
+	other := newTestTieredCache(&published)
+
+	require.NoError(t, c.Save(&render.Result{URL: "https://netlify.com/", HTML: "<html>old</html>"}, time.Hour))
+	assert.Equal(t, "<html>old</html>", checkURL(t, other, "https://netlify.com/").HTML)
+
+	require.NoError(t, c.Save(&render.Result{URL: "https://netlify.com/", HTML: "<html>new</html>"}, time.Hour))
+	assert.Equal(t, []string{"https://netlify.com/", "https://netlify.com/"}, published)
+	// other still has the old page until the message arrives
+	assert.Equal(t, "<html>old</html>", checkURL(t, other, "https://netlify.com/").HTML)
+	other.invalidate("https://netlify.com/")
+	assert.Equal(t, "<html>new</html>", checkURL(t, other, "https://netlify.com/").HTML)
+}
+
+func TestTieredCacheSharedExpiry(t *testing.T) {
+	s.FlushAll()
+	var published []string
+	c := newTestTieredCache(&published)
+
+	require.NoError(t, client.Save(&render.Result{URL: "https://netlify.com/", Status: http.StatusGatewayTimeout}, 100*time.Millisecond))
+	res := checkURL(t, c, "https://netlify.com/")
+	require.NotNil(t, res)
+	assert.Equal(t, http.StatusGatewayTimeout, res.Status)
+
+	// the local copy expires with the shared entry rather than after the local TTL
+	s.FlushAll()
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, checkURL(t, c, "https://netlify.com/"))
+}
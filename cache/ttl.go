@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTTL is how long a result is cached when the origin gives no expiry
+const DefaultTTL = 24 * time.Hour
+
+// TTLPolicy derives how long a result is cached from the
+// Cache-Control and Expires headers of the origin response
+type TTLPolicy struct {
+	// Default is used when the origin gives no expiry, DefaultTTL if zero
+	Default time.Duration
+	// Min is the shortest TTL. Results the origin allows to be cached
+	// for less are cached for Min, or not at all if Min is zero.
+	Min time.Duration
+	// Max is the longest TTL, unlimited if zero
+	Max time.Duration
+	// Stale is how long a result may still be served after its TTL
+	// while it is rendered again, zero to expire results at their TTL
+	Stale time.Duration
+
+	// The TTLs of results other than rendered pages. Zero values
+	// disable caching of the corresponding results.
+
+	// NotFound is the TTL of 404 and 410 results
+	NotFound time.Duration
+	// Error is the TTL of 5xx results from the origin
+	Error time.Duration
+	// Redirect is the TTL of 3xx results
+	Redirect time.Duration
+	// Timeout is the TTL of renders that timed out, cached as 504 results
+	Timeout time.Duration
+}
+
+// TTL returns how long a result with the origin headers may be cached.
+// It returns false if the result must not be cached.
+func (p TTLPolicy) TTL(header http.Header, now time.Time) (time.Duration, bool) {
+	ttl, ok := p.originTTL(header, now)
+	if !ok {
+		return 0, false
+	}
+	if ttl < p.Min {
+		ttl = p.Min
+	}
+	if p.Max > 0 && ttl > p.Max {
+		ttl = p.Max
+	}
+	return ttl, ttl > 0
+}
+
+// NegativeTTL returns how long an origin response with a status other
+// than 200 may be cached. It returns false if it must not be cached.
+func (p TTLPolicy) NegativeTTL(status int) (time.Duration, bool) {
+	var ttl time.Duration
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		ttl = p.NotFound
+	case status >= 500 && status <= 599:
+		ttl = p.Error
+	case status >= 300 && status <= 399 && status != http.StatusNotModified:
+		ttl = p.Redirect
+	}
+	return ttl, ttl > 0
+}
+
+func (p TTLPolicy) originTTL(header http.Header, now time.Time) (time.Duration, bool) {
+	directives := cacheControl(header)
+	// prerender is a shared cache, private responses are meant for a single user
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["private"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0, true
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[name]; ok {
+			if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+				if seconds < 0 {
+					seconds = 0
+				}
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+	}
+
+	if v := header.Get("Expires"); v != "" {
+		// an invalid date, such as 0, means already expired
+		expires, err := http.ParseTime(v)
+		if err != nil || !expires.After(now) {
+			return 0, true
+		}
+		return expires.Sub(now), true
+	}
+
+	if p.Default > 0 {
+		return p.Default, true
+	}
+	return DefaultTTL, true
+}
+
+// cacheControl returns the Cache-Control directives by lowercase name
+func cacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, line := range header["Cache-Control"] {
+		for _, d := range strings.Split(line, ",") {
+			parts := strings.SplitN(strings.TrimSpace(d), "=", 2)
+			name := strings.ToLower(parts[0])
+			if name == "" {
+				continue
+			}
+			if len(parts) == 2 {
+				directives[name] = strings.Trim(parts[1], `"`)
+			} else {
+				directives[name] = ""
+			}
+		}
+	}
+	return directives
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTL(t *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	policy := TTLPolicy{Min: time.Minute, Max: 7 * 24 * time.Hour}
+
+	cases := []struct {
+		name   string
+		header http.Header
+		ttl    time.Duration
+		ok     bool
+	}{
+		{"no headers", http.Header{}, DefaultTTL, true},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=3600"}}, time.Hour, true},
+		{"s-maxage wins", http.Header{"Cache-Control": {"max-age=60, s-maxage=7200"}}, 2 * time.Hour, true},
+		{"below min", http.Header{"Cache-Control": {"max-age=5"}}, time.Minute, true},
+		{"above max", http.Header{"Cache-Control": {"max-age=31536000"}}, 7 * 24 * time.Hour, true},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, time.Minute, true},
+		{"no-store", http.Header{"Cache-Control": {"max-age=3600", "No-Store"}}, 0, false},
+		{"private", http.Header{"Cache-Control": {"private, max-age=3600"}}, 0, false},
+		{"expires", http.Header{"Expires": {"Sun, 01 Oct 2017 14:00:00 GMT"}}, 2 * time.Hour, true},
+		{"expired", http.Header{"Expires": {"0"}}, time.Minute, true},
+		{"max-age over expires", http.Header{"Cache-Control": {"max-age=600"}, "Expires": {"Sun, 01 Oct 2017 14:00:00 GMT"}}, 10 * time.Minute, true},
+	}
+	for _, c := range cases {
+		ttl, ok := policy.TTL(c.header, now)
+		assert.Equal(t, c.ok, ok, c.name)
+		assert.Equal(t, c.ttl, ttl, c.name)
+	}
+}
+
+func TestTTLWithoutMin(t *testing.T) {
+	now := time.Now()
+	policy := TTLPolicy{Default: time.Hour}
+
+	ttl, ok := policy.TTL(http.Header{}, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, ttl)
+
+	_, ok = policy.TTL(http.Header{"Cache-Control": {"max-age=0"}}, now)
+	assert.False(t, ok)
+}
+
+func TestNegativeTTL(t *testing.T) {
+	policy := TTLPolicy{NotFound: time.Hour, Error: time.Minute}
+
+	cases := []struct {
+		status int
+		ttl    time.Duration
+		ok     bool
+	}{
+		{http.StatusNotFound, time.Hour, true},
+		{http.StatusGone, time.Hour, true},
+		{http.StatusServiceUnavailable, time.Minute, true},
+		{http.StatusMovedPermanently, 0, false},
+		{http.StatusForbidden, 0, false},
+	}
+	for _, c := range cases {
+		ttl, ok := policy.NegativeTTL(c.status)
+		assert.Equal(t, c.ok, ok, "status %d", c.status)
+		assert.Equal(t, c.ttl, ttl, "status %d", c.status)
+	}
+}
@@ -16,6 +16,10 @@ func (c contextKey) String() string {
 const (
 	rendererKey = contextKey("renderer")
 	cacheKey    = contextKey("cache")
+	defaultsKey = contextKey("defaults")
+	ttlKey      = contextKey("ttl")
+	lockKey     = contextKey("lock")
+	urlKey      = contextKey("url")
 )
 
 func setRenderer(ctx context.Context, r render.Renderer) context.Context {
@@ -36,3 +40,41 @@ func getCache(ctx context.Context) cache.Cache {
 	}
 	return nil
 }
+
+func setDefaults(ctx context.Context, d *renderDefaults) context.Context {
+	return context.WithValue(ctx, defaultsKey, d)
+}
+func getDefaults(ctx context.Context) *renderDefaults {
+	d, _ := ctx.Value(defaultsKey).(*renderDefaults)
+	if d == nil {
+		return &renderDefaults{}
+	}
+	return d
+}
+
+func setTTLPolicy(ctx context.Context, p cache.TTLPolicy) context.Context {
+	return context.WithValue(ctx, ttlKey, p)
+}
+func getTTLPolicy(ctx context.Context) cache.TTLPolicy {
+	p, _ := ctx.Value(ttlKey).(cache.TTLPolicy)
+	return p
+}
+
+func setLockSettings(ctx context.Context, l lockSettings) context.Context {
+	return context.WithValue(ctx, lockKey, l)
+}
+func getLockSettings(ctx context.Context) lockSettings {
+	l, _ := ctx.Value(lockKey).(lockSettings)
+	return l
+}
+
+func setNormalizer(ctx context.Context, n *urlNormalizer) context.Context {
+	return context.WithValue(ctx, urlKey, n)
+}
+func getNormalizer(ctx context.Context) *urlNormalizer {
+	n, _ := ctx.Value(urlKey).(*urlNormalizer)
+	if n == nil {
+		return &urlNormalizer{}
+	}
+	return n
+}
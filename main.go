@@ -5,6 +5,9 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -20,33 +23,150 @@ import (
 func main() {
 	var renderer render.Renderer
 	var err error
-	renderer, err = render.NewRenderer()
+	if n, perr := strconv.Atoi(os.Getenv("CHROME_PROCESSES")); perr == nil && n > 1 {
+		renderer, err = render.NewRendererPool(n, render.DefaultDebugPort)
+	} else {
+		renderer, err = render.NewRenderer()
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	// cache misses are coalesced above the render lock, this covers
+	// background renders and renders without a cache
+	renderer = render.Coalesce(renderer)
 	defer renderer.Close()
 	if os.Getenv("RENDER_TIMEOUT") != "" {
 		if t, perr := time.ParseDuration(os.Getenv("RENDER_TIMEOUT")); perr == nil {
 			renderer.SetPageLoadTimeout(t)
 		}
 	}
+	maxTabs, maxQueue, queueTimeout := render.DefaultMaxTabs, render.DefaultMaxQueue, render.DefaultQueueTimeout
+	if os.Getenv("MAX_TABS") != "" {
+		if n, perr := strconv.Atoi(os.Getenv("MAX_TABS")); perr == nil {
+			maxTabs = n
+		}
+	}
+	if os.Getenv("TAB_QUEUE_SIZE") != "" {
+		if n, perr := strconv.Atoi(os.Getenv("TAB_QUEUE_SIZE")); perr == nil {
+			maxQueue = n
+		}
+	}
+	if os.Getenv("TAB_QUEUE_TIMEOUT") != "" {
+		if t, perr := time.ParseDuration(os.Getenv("TAB_QUEUE_TIMEOUT")); perr == nil {
+			queueTimeout = t
+		}
+	}
+	renderer.SetTabLimit(maxTabs, maxQueue, queueTimeout)
 
-	redisAddr := os.Getenv("REDIS_URL")
-	if redisAddr == "" {
-		redisAddr = "redis://localhost:6379/0"
+	var recycle render.RecyclePolicy
+	if n, perr := strconv.Atoi(os.Getenv("RECYCLE_RENDERS")); perr == nil {
+		recycle.MaxRenders = n
 	}
-	opts, err := redis.ParseURL(redisAddr)
+	if t, perr := time.ParseDuration(os.Getenv("RECYCLE_AGE")); perr == nil {
+		recycle.MaxAge = t
+	}
+	if n, perr := strconv.ParseInt(os.Getenv("RECYCLE_RSS_MB"), 10, 64); perr == nil {
+		recycle.MaxRSS = n << 20
+	}
+	renderer.SetRecyclePolicy(recycle)
+
+	defaults := &renderDefaults{detectDevice: true}
+	if os.Getenv("RENDER_WAIT") != "" {
+		if defaults.options.Wait, err = parseWait(os.Getenv("RENDER_WAIT")); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if t, perr := time.ParseDuration(os.Getenv("RENDER_IDLE_TIME")); perr == nil {
+		defaults.options.IdleTime = t
+	}
+	if follow, perr := strconv.ParseBool(os.Getenv("FOLLOW_REDIRECTS")); perr == nil {
+		defaults.options.ReturnRedirects = !follow
+	}
+	if v := os.Getenv("FORWARD_HEADERS"); v != "" {
+		defaults.options.ForwardHeaders = []string{}
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				defaults.options.ForwardHeaders = append(defaults.options.ForwardHeaders, name)
+			}
+		}
+	}
+	if detect, perr := strconv.ParseBool(os.Getenv("DETECT_DEVICE")); perr == nil {
+		defaults.detectDevice = detect
+	}
+	if defaults.selectors, err = parseSelectors(os.Getenv("RENDER_WAIT_SELECTORS")); err != nil {
+		log.Fatal(err)
+	}
+
+	var ttl cache.TTLPolicy
+	if t, perr := time.ParseDuration(os.Getenv("CACHE_TTL")); perr == nil {
+		ttl.Default = t
+	}
+	if t, perr := time.ParseDuration(os.Getenv("CACHE_MIN_TTL")); perr == nil {
+		ttl.Min = t
+	}
+	if t, perr := time.ParseDuration(os.Getenv("CACHE_MAX_TTL")); perr == nil {
+		ttl.Max = t
+	}
+	if t, perr := time.ParseDuration(os.Getenv("CACHE_STALE_TTL")); perr == nil {
+		ttl.Stale = t
+	}
+	if t, perr := time.ParseDuration(os.Getenv("CACHE_NOT_FOUND_TTL")); perr == nil {
+		ttl.NotFound = t
+	}
+	if t, perr := time.ParseDuration(os.Getenv("CACHE_ERROR_TTL")); perr == nil {
+		ttl.Error = t
+	}
+	if t, perr := time.ParseDuration(os.Getenv("CACHE_REDIRECT_TTL")); perr == nil {
+		ttl.Redirect = t
+	}
+	if t, perr := time.ParseDuration(os.Getenv("CACHE_TIMEOUT_TTL")); perr == nil {
+		ttl.Timeout = t
+	}
+
+	lock := lockSettings{ttl: defaultLockTTL, wait: defaultLockWait}
+	if t, perr := time.ParseDuration(os.Getenv("RENDER_LOCK_TTL")); perr == nil {
+		lock.ttl = t
+	}
+	if t, perr := time.ParseDuration(os.Getenv("RENDER_LOCK_WAIT")); perr == nil {
+		lock.wait = t
+	}
+
+	normalizer := &urlNormalizer{stripParams: defaultStripParams}
+	if v, ok := os.LookupEnv("URL_STRIP_PARAMS"); ok {
+		normalizer.stripParams = splitList(v)
+	}
+	if normalizer.trailingSlash, err = parseTrailingSlash(os.Getenv("URL_TRAILING_SLASH")); err != nil {
+		log.Fatal(err)
+	}
+
+	resultCache, closeCache, err := newCache()
 	if err != nil {
-		log.Fatal("error parsing redis url", err)
+		log.Fatal(err)
 	}
-	client := redis.NewClient(opts)
-	defer client.Close()
+	defer closeCache()
+
+	// closed on shutdown to abandon renders still in progress
+	shutdown := make(chan struct{})
 
 	// a custom handler is necessary because ServeMux redirects // to /
 	// in all urls, regardless of escaping
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := setRenderer(r.Context(), renderer)
-		ctx = setCache(ctx, cache.NewCache(client))
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ctx = setRenderer(ctx, renderer)
+		ctx = setDefaults(ctx, defaults)
+		ctx = setCache(ctx, resultCache)
+		ctx = setTTLPolicy(ctx, ttl)
+		ctx = setLockSettings(ctx, lock)
+		ctx = setNormalizer(ctx, normalizer)
 		handle(w, r.WithContext(ctx))
 	})
 	wrappedHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -73,6 +193,7 @@ func main() {
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		<-c
 		log.Info("signal caught, shutting down")
+		close(shutdown)
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		server.Shutdown(ctx)
@@ -83,3 +204,125 @@ func main() {
 		log.Error(err)
 	}
 }
+
+// newCache creates the cache backend selected by CACHE_BACKEND, which
+// defaults to Redis if it is configured and no cache otherwise. The
+// returned function releases the backend's resources.
+func newCache() (cache.Cache, func(), error) {
+	backend := os.Getenv("CACHE_BACKEND")
+	if backend == "" {
+		for _, env := range []string{"REDIS_URL", "REDIS_SENTINEL_ADDRS", "REDIS_CLUSTER_ADDRS"} {
+			if os.Getenv(env) != "" {
+				backend = "redis"
+			}
+		}
+	}
+
+	switch backend {
+	case "", "none":
+		return nil, func() {}, nil
+	case "redis":
+		client, err := newRedisClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		return cache.NewCache(client), func() { client.Close() }, nil
+	case "memory":
+		return cache.NewMemoryCache(memoryBudget()), func() {}, nil
+	case "tiered":
+		client, err := newRedisClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		localTTL := cache.DefaultLocalTTL
+		if t, perr := time.ParseDuration(os.Getenv("CACHE_LOCAL_TTL")); perr == nil {
+			localTTL = t
+		}
+		c, unsubscribe := cache.NewTieredCache(client, memoryBudget(), localTTL)
+		return c, func() {
+			unsubscribe()
+			client.Close()
+		}, nil
+	case "s3":
+		config := cache.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+		if config.Bucket == "" {
+			return nil, nil, fmt.Errorf("S3_BUCKET is required for the s3 cache backend")
+		}
+		if config.Region == "" {
+			config.Region = "us-east-1"
+		}
+		if config.Endpoint == "" {
+			config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+		}
+		return cache.NewS3Cache(config), func() {}, nil
+	case "file":
+		dir := os.Getenv("CACHE_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "prerender-cache")
+		}
+		c, err := cache.NewFileCache(dir)
+		return c, func() {}, err
+	default:
+		return nil, nil, fmt.Errorf("unknown cache backend %q", backend)
+	}
+}
+
+// newRedisClient connects to Redis Sentinel if REDIS_SENTINEL_ADDRS is set,
+// to Redis Cluster if REDIS_CLUSTER_ADDRS is set and to REDIS_URL otherwise
+func newRedisClient() (redis.UniversalClient, error) {
+	if addrs := splitList(os.Getenv("REDIS_SENTINEL_ADDRS")); len(addrs) > 0 {
+		master := os.Getenv("REDIS_SENTINEL_MASTER")
+		if master == "" {
+			return nil, fmt.Errorf("REDIS_SENTINEL_MASTER is required with REDIS_SENTINEL_ADDRS")
+		}
+		db, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    master,
+			SentinelAddrs: addrs,
+			Password:      os.Getenv("REDIS_PASSWORD"),
+			DB:            db,
+		}), nil
+	}
+	if addrs := splitList(os.Getenv("REDIS_CLUSTER_ADDRS")); len(addrs) > 0 {
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    addrs,
+			Password: os.Getenv("REDIS_PASSWORD"),
+		}), nil
+	}
+
+	redisAddr := os.Getenv("REDIS_URL")
+	if redisAddr == "" {
+		redisAddr = "redis://localhost:6379/0"
+	}
+	opts, err := redis.ParseURL(redisAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing redis url: %s", err)
+	}
+	return redis.NewClient(opts), nil
+}
+
+// splitList splits a comma separated list, ignoring empty items
+func splitList(v string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(v, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// memoryBudget is the size of the in-memory cache in bytes
+func memoryBudget() int64 {
+	if n, perr := strconv.ParseInt(os.Getenv("CACHE_MEMORY_MB"), 10, 64); perr == nil {
+		return n << 20
+	}
+	return cache.DefaultMemoryBudget
+}
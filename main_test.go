@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/brycekahle/prerender/cache"
 	"github.com/brycekahle/prerender/render"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -51,23 +53,36 @@ type MockRenderer struct {
 	mock.Mock
 }
 
-func (r *MockRenderer) Render(url string) (*render.Result, error) {
+func (r *MockRenderer) Render(ctx context.Context, url string, opts render.Options) (*render.Result, error) {
 	args := r.Called(url)
 	err := args.Error(0)
 	if err != nil {
 		return nil, err
 	}
 
-	return &render.Result{
+	res := &render.Result{
 		URL:      url,
 		Status:   args.Int(1),
 		HTML:     args.String(2),
 		Etag:     args.String(3),
 		Duration: time.Duration(args.Int(4)),
-	}, nil
+	}
+	if len(args) > 5 {
+		res.Headers, _ = args.Get(5).(http.Header)
+		res.CacheHeaders = res.Headers
+	}
+	if len(args) > 6 {
+		res.Redirects = args.Get(6).([]render.Redirect)
+	}
+	return res, nil
+}
+func (r *MockRenderer) Close()                                         {}
+func (r *MockRenderer) SetPageLoadTimeout(t time.Duration)             {}
+func (r *MockRenderer) SetTabLimit(max, queue int, wait time.Duration) {}
+func (r *MockRenderer) SetRecyclePolicy(p render.RecyclePolicy)        {}
+func (r *MockRenderer) Stats() render.Stats {
+	return render.Stats{ActiveTabs: 2, Queued: 1, Restarts: 3, Recycles: 4}
 }
-func (r *MockRenderer) Close()                             {}
-func (r *MockRenderer) SetPageLoadTimeout(t time.Duration) {}
 
 func TestETag(t *testing.T) {
 	r := new(MockRenderer)
@@ -98,6 +113,22 @@ func TestNon200(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 }
 
+func TestResultHeaders(t *testing.T) {
+	r := new(MockRenderer)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setRenderer(req.Context(), r)
+	w := httptest.NewRecorder()
+
+	headers := http.Header{"Location": {"https://netlify.com/new"}}
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusMovedPermanently, "<html></html>", "", 1, headers).Once()
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "https://netlify.com/new", resp.Header.Get("Location"))
+}
+
 func TestPageLoadTimeout(t *testing.T) {
 	r := new(MockRenderer)
 	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
@@ -112,6 +143,78 @@ func TestPageLoadTimeout(t *testing.T) {
 	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
 }
 
+func TestSelectorTimeout(t *testing.T) {
+	r := new(MockRenderer)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setRenderer(req.Context(), r)
+	w := httptest.NewRecorder()
+
+	r.On("Render", "https://netlify.com/").Return(render.ErrSelectorTimeout).Once()
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Equal(t, render.ErrSelectorTimeout.Error(), string(body))
+}
+
+func TestClientCanceled(t *testing.T) {
+	r := new(MockRenderer)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setRenderer(req.Context(), r)
+	w := httptest.NewRecorder()
+
+	r.On("Render", "https://netlify.com/").Return(context.Canceled).Once()
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	r.AssertExpectations(t)
+	assert.Equal(t, statusClientClosedRequest, resp.StatusCode)
+}
+
+func TestQueueFull(t *testing.T) {
+	r := new(MockRenderer)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setRenderer(req.Context(), r)
+	w := httptest.NewRecorder()
+
+	r.On("Render", "https://netlify.com/").Return(render.ErrQueueFull).Once()
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestChromeTerminated(t *testing.T) {
+	r := new(MockRenderer)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setRenderer(req.Context(), r)
+	w := httptest.NewRecorder()
+
+	r.On("Render", "https://netlify.com/").Return(render.ErrChromeTerminated).Once()
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestStats(t *testing.T) {
+	r := new(MockRenderer)
+	req := httptest.NewRequest("GET", "http://example.com/_stats", nil)
+	ctx := setRenderer(req.Context(), r)
+	w := httptest.NewRecorder()
+
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"active_tabs":2,"queued":1,"restarts":3,"recycles":4}`, string(body))
+}
+
 func TestRenderError(t *testing.T) {
 	r := new(MockRenderer)
 	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
@@ -141,13 +244,17 @@ func (c *MockCache) Check(r *http.Request) (*render.Result, error) {
 		return nil, nil
 	}
 
-	return &render.Result{
+	res := &render.Result{
 		URL:      r.URL.Path,
 		Status:   args.Int(1),
 		HTML:     args.String(2),
 		Etag:     args.String(3),
 		Duration: time.Duration(args.Int(4)),
-	}, nil
+	}
+	if len(args) > 5 {
+		res.FreshUntil = args.Get(5).(time.Time)
+	}
+	return res, nil
 }
 
 func (c *MockCache) Save(res *render.Result, ttl time.Duration) error {
@@ -155,6 +262,21 @@ func (c *MockCache) Save(res *render.Result, ttl time.Duration) error {
 	return args.Error(0)
 }
 
+type MockLockingCache struct {
+	MockCache
+}
+
+func (c *MockLockingCache) Lock(url string, ttl time.Duration) (func() error, error) {
+	args := c.Called(url, ttl)
+	if err := args.Error(0); err != nil {
+		return nil, err
+	}
+	return func() error {
+		c.MethodCalled("unlock", url)
+		return nil
+	}, nil
+}
+
 func TestCacheHit(t *testing.T) {
 	c := new(MockCache)
 	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
@@ -201,6 +323,60 @@ func TestCacheMiss(t *testing.T) {
 	assert.Equal(t, "<html></html>", string(body))
 }
 
+func TestRedirectNotCached(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, 0).Once()
+	redirects := []render.Redirect{{URL: "https://netlify.com/", Status: http.StatusMovedPermanently, Location: "https://www.netlify.com/"}}
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusOK, "<html></html>", "etagetag", 1, nil, redirects).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	c.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRedirectToNotFoundNotCached(t *testing.T) {
+	c := new(MockCache)
+	policy := cache.TTLPolicy{NotFound: 10 * time.Minute, Redirect: 10 * time.Minute}
+	redirects := []render.Redirect{{URL: "https://netlify.com/", Status: http.StatusMovedPermanently, Location: "https://www.netlify.com/"}}
+
+	res := &render.Result{URL: "https://netlify.com/", Status: http.StatusNotFound, Redirects: redirects}
+	assert.NoError(t, saveResult(c, policy, res))
+	c.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+
+	// the returned redirect is the result of the source URL
+	res = &render.Result{URL: "https://netlify.com/", Status: http.StatusMovedPermanently, Redirects: redirects, ReturnRedirects: true}
+	c.On("Save", res, 10*time.Minute).Return(nil).Once()
+	assert.NoError(t, saveResult(c, policy, res))
+	c.AssertExpectations(t)
+}
+
+func TestCacheReturnRedirects(t *testing.T) {
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.Header.Set("X-Prerender-Follow-Redirects", "0")
+	ctx := setCache(req.Context(), c)
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.MatchedBy(func(r *http.Request) bool {
+		return r.Header.Get("X-Prerender-Follow-Redirects") == "false"
+	})).Return(nil, http.StatusMovedPermanently, "", "", 0).Once()
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	assert.Equal(t, http.StatusMovedPermanently, w.Result().StatusCode)
+	assert.Contains(t, w.Result().Header["Vary"], "X-Prerender-Follow-Redirects")
+}
+
 func TestCacheCheckError(t *testing.T) {
 	c := new(MockCache)
 	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
@@ -217,6 +393,397 @@ func TestCacheCheckError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
 }
 
+func TestCacheOriginTTL(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setTTLPolicy(ctx, cache.TTLPolicy{Max: time.Hour})
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, 0).Once()
+	c.On("Save", mock.Anything, time.Hour).Return(nil).Once()
+	headers := http.Header{"Cache-Control": {"max-age=86400"}}
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusOK, "<html></html>", "etagetag", 1, headers).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestCacheNoStore(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, 0).Once()
+	headers := http.Header{"Cache-Control": {"no-store"}}
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusOK, "<html></html>", "etagetag", 1, headers).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	c.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "no-store", w.Result().Header.Get("Cache-Control"))
+}
+
+func TestCacheNoStoreNotForwarded(t *testing.T) {
+	c := new(MockCache)
+	res := &render.Result{
+		URL:          "https://netlify.com/",
+		Status:       http.StatusOK,
+		Headers:      http.Header{"Link": {"<https://netlify.com/>; rel=canonical"}},
+		CacheHeaders: http.Header{"Cache-Control": {"private"}},
+	}
+
+	assert.NoError(t, saveResult(c, cache.TTLPolicy{}, res))
+	c.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+}
+
+func TestCacheStale(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setTTLPolicy(ctx, cache.TTLPolicy{Default: time.Hour, Stale: time.Minute})
+	w := httptest.NewRecorder()
+
+	saved := make(chan *render.Result, 1)
+	c.On("Check", mock.Anything).Return(nil, http.StatusOK, "<html>old</html>", "etagetag", 1, time.Now().Add(-time.Second)).Once()
+	c.On("Save", mock.Anything, time.Hour+time.Minute).Return(nil).Once().Run(func(args mock.Arguments) {
+		saved <- args.Get(0).(*render.Result)
+	})
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusOK, "<html>new</html>", "newetag", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "stale", resp.Header.Get("X-Prerender-Cache"))
+	assert.Equal(t, "<html>old</html>", string(body))
+
+	select {
+	case res := <-saved:
+		assert.Equal(t, "<html>new</html>", res.HTML)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), res.FreshUntil, time.Minute)
+	case <-time.After(time.Second):
+		t.Fatal("stale result was not rendered again")
+	}
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+}
+
+func TestCacheFresh(t *testing.T) {
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, http.StatusOK, "<html></html>", "etagetag", 1, time.Now().Add(time.Minute)).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	c.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "fresh", resp.Header.Get("X-Prerender-Cache"))
+}
+
+func TestNegativeCacheNotFound(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setTTLPolicy(ctx, cache.TTLPolicy{NotFound: 10 * time.Minute})
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, 0).Once()
+	c.On("Save", mock.MatchedBy(func(r *render.Result) bool {
+		return r.Status == http.StatusNotFound
+	}), 10*time.Minute).Return(nil).Once()
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusNotFound, "", "", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+}
+
+func TestNegativeCacheDisabled(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, 0).Once()
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusBadGateway, "", "", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	c.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+	assert.Equal(t, http.StatusBadGateway, w.Result().StatusCode)
+}
+
+func TestNegativeCacheTimeout(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setTTLPolicy(ctx, cache.TTLPolicy{Timeout: time.Minute})
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, 0).Once()
+	c.On("Save", &render.Result{URL: "https://netlify.com/", Status: http.StatusGatewayTimeout}, time.Minute).Return(nil).Once()
+	r.On("Render", "https://netlify.com/").Return(render.ErrPageLoadTimeout).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Result().StatusCode)
+}
+
+func TestNegativeCacheHit(t *testing.T) {
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, http.StatusGone, "", "", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	assert.Equal(t, http.StatusGone, w.Result().StatusCode)
+}
+
+func TestRenderLockAcquired(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockLockingCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setLockSettings(ctx, lockSettings{ttl: time.Minute, wait: time.Second})
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.Anything).Return(nil, 0).Twice()
+	c.On("Lock", "https://netlify.com/", time.Minute).Return(nil).Once()
+	c.On("Save", mock.Anything, 24*time.Hour).Return(nil).Once()
+	c.On("unlock", "https://netlify.com/").Once()
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusOK, "<html></html>", "etagetag", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestRenderLockAcquiredCached(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockLockingCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setLockSettings(ctx, lockSettings{ttl: time.Minute, wait: time.Second})
+	w := httptest.NewRecorder()
+
+	// another instance cached the page just before releasing the lock
+	c.On("Check", mock.Anything).Return(nil, 0).Once()
+	c.On("Lock", "https://netlify.com/", time.Minute).Return(nil).Once()
+	c.On("Check", mock.Anything).Return(nil, http.StatusOK, "<html></html>", "etagetag", 1).Once()
+	c.On("unlock", "https://netlify.com/").Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertNotCalled(t, "Render", mock.Anything)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+// startRendering registers a render of the URL by another request of this instance
+func startRendering(key string) *renderCall {
+	call := &renderCall{done: make(chan struct{})}
+	rendering.Lock()
+	rendering.calls[key] = call
+	rendering.Unlock()
+	return call
+}
+
+// finishRendering ends a render registered with startRendering
+func finishRendering(key string, call *renderCall, res *render.Result, err error) {
+	call.res, call.err = res, err
+	rendering.Lock()
+	delete(rendering.calls, key)
+	rendering.Unlock()
+	close(call.done)
+}
+
+func TestRenderCoalesced(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockLockingCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setLockSettings(ctx, lockSettings{ttl: time.Minute, wait: time.Second})
+	w := httptest.NewRecorder()
+
+	// the page could not be cached, so the rendered result is shared
+	c.On("Check", mock.Anything).Return(nil, 0).Twice()
+	key, _ := render.Key("https://netlify.com/", render.Options{})
+	call := startRendering(key)
+	go finishRendering(key, call, &render.Result{Status: http.StatusOK, HTML: "<html></html>"}, nil)
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertNotCalled(t, "Render", mock.Anything)
+	c.AssertNotCalled(t, "Lock", mock.Anything, mock.Anything)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "<html></html>", w.Body.String())
+}
+
+func TestRenderCoalescedCanceled(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockLockingCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setLockSettings(ctx, lockSettings{ttl: time.Minute, wait: time.Second})
+	w := httptest.NewRecorder()
+
+	// the request rendering the page went away, so the page is rendered again
+	c.On("Check", mock.Anything).Return(nil, 0).Times(3)
+	c.On("Lock", "https://netlify.com/", time.Minute).Return(nil).Once()
+	c.On("Save", mock.Anything, 24*time.Hour).Return(nil).Once()
+	c.On("unlock", "https://netlify.com/").Once()
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusOK, "<html></html>", "etagetag", 1).Once()
+	key, _ := render.Key("https://netlify.com/", render.Options{})
+	call := startRendering(key)
+	go finishRendering(key, call, nil, context.Canceled)
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestRenderNotCoalescedOptions(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockLockingCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.Header.Set(cookieHeader, "session=one")
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setLockSettings(ctx, lockSettings{ttl: time.Minute, wait: time.Second})
+	w := httptest.NewRecorder()
+
+	// a render with another cookie is not shared
+	other := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	other.Header.Set(cookieHeader, "session=two")
+	opts, _ := renderOptions(other)
+	key, _ := render.Key("https://netlify.com/", opts)
+	call := startRendering(key)
+	defer finishRendering(key, call, &render.Result{Status: http.StatusOK, HTML: "<html>two</html>"}, nil)
+
+	// renders with cookies bypass the cache
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusOK, "<html>one</html>", "etagetag", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	r.AssertExpectations(t)
+	c.AssertNotCalled(t, "Check", mock.Anything)
+	c.AssertNotCalled(t, "Lock", mock.Anything, mock.Anything)
+	c.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "<html>one</html>", w.Body.String())
+}
+
+func TestCustomOptionsNotCached(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockLockingCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.Header.Set(timeoutHeader, "1s")
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setTTLPolicy(ctx, cache.TTLPolicy{Timeout: time.Minute})
+	w := httptest.NewRecorder()
+
+	// a short timeout must not cache a timeout for everyone else
+	r.On("Render", "https://netlify.com/").Return(render.ErrPageLoadTimeout).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	r.AssertExpectations(t)
+	c.AssertNotCalled(t, "Check", mock.Anything)
+	c.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Result().StatusCode)
+}
+
+func TestRenderLockWait(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockLockingCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setLockSettings(ctx, lockSettings{ttl: time.Minute, wait: time.Second})
+	w := httptest.NewRecorder()
+
+	// another instance renders the page and caches it
+	c.On("Lock", "https://netlify.com/", time.Minute).Return(cache.ErrLocked).Twice()
+	c.On("Check", mock.Anything).Return(nil, 0).Twice()
+	c.On("Check", mock.Anything).Return(nil, http.StatusOK, "<html></html>", "etagetag", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	c.AssertExpectations(t)
+	r.AssertNotCalled(t, "Render", mock.Anything)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "<html></html>", string(body))
+}
+
+func TestRenderLockWaitExpired(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockLockingCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setLockSettings(ctx, lockSettings{ttl: time.Minute, wait: 250 * time.Millisecond})
+	w := httptest.NewRecorder()
+
+	c.On("Lock", "https://netlify.com/", time.Minute).Return(cache.ErrLocked)
+	c.On("Check", mock.Anything).Return(nil, 0)
+	c.On("Save", mock.Anything, 24*time.Hour).Return(nil).Once()
+	r.On("Render", "https://netlify.com/").Return(nil, http.StatusOK, "<html></html>", "etagetag", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	c.AssertNotCalled(t, "unlock", mock.Anything)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
 func TestCacheSaveError(t *testing.T) {
 	r := new(MockRenderer)
 	c := new(MockCache)
@@ -240,3 +807,64 @@ func TestCacheSaveError(t *testing.T) {
 	r.AssertExpectations(t)
 	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
 }
+
+func TestWriteResultGzip(t *testing.T) {
+	w := httptest.NewRecorder()
+	gzipped := []byte{0x1f, 0x8b, 0x08}
+	writeResult(&render.Result{Status: http.StatusOK, GzipHTML: gzipped, Etag: "etagetag"}, nil, w)
+
+	resp := w.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, gzipped, body)
+
+	// the origin's content type is kept
+	w = httptest.NewRecorder()
+	writeResult(&render.Result{Status: http.StatusOK, GzipHTML: gzipped, Headers: http.Header{"Content-Type": {"application/xhtml+xml"}}}, nil, w)
+	assert.Equal(t, "application/xhtml+xml", w.Result().Header.Get("Content-Type"))
+}
+
+func TestNormalizedURL(t *testing.T) {
+	r := new(MockRenderer)
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://Netlify.com:443/blog?utm_source=x&b=2&a=1", nil)
+	ctx := setCache(req.Context(), c)
+	ctx = setRenderer(ctx, r)
+	ctx = setNormalizer(ctx, &urlNormalizer{stripParams: defaultStripParams})
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.MatchedBy(func(r *http.Request) bool {
+		return r.URL.Path == "https://netlify.com/blog?a=1&b=2"
+	})).Return(nil, 0).Once()
+	c.On("Save", mock.Anything, 24*time.Hour).Return(nil).Once()
+	r.On("Render", "https://netlify.com/blog?a=1&b=2").Return(nil, http.StatusOK, "<html></html>", "etagetag", 1).Once()
+
+	handle(w, req.WithContext(ctx))
+
+	c.AssertExpectations(t)
+	r.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+}
+
+func TestCacheDevice(t *testing.T) {
+	c := new(MockCache)
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPad; CPU OS 11_0 like Mac OS X)")
+	ctx := setCache(req.Context(), c)
+	ctx = setDefaults(ctx, &renderDefaults{detectDevice: true})
+	w := httptest.NewRecorder()
+
+	c.On("Check", mock.MatchedBy(func(r *http.Request) bool {
+		return r.Header.Get("X-Prerender-Device") == "tablet"
+	})).Return(nil, http.StatusOK, "<html></html>", "etagetag", 0).Once()
+	handle(w, req.WithContext(ctx))
+
+	resp := w.Result()
+	c.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header["Vary"], "X-Prerender-Device")
+	assert.Contains(t, resp.Header["Vary"], "User-Agent")
+}
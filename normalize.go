@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// defaultStripParams are tracking parameters that do not change the page
+var defaultStripParams = []string{"utm_*", "gclid", "fbclid", "msclkid", "mc_cid", "mc_eid", "_ga"}
+
+// trailing slash policies
+const (
+	trailingSlashKeep   = "keep"
+	trailingSlashAdd    = "add"
+	trailingSlashRemove = "remove"
+)
+
+// urlNormalizer rewrites target URLs so variations of the same page
+// share renders, cache entries and locks
+type urlNormalizer struct {
+	// stripParams are removed from the query, names ending in * match as a prefix
+	stripParams   []string
+	trailingSlash string
+}
+
+func parseTrailingSlash(s string) (string, error) {
+	switch s {
+	case "", trailingSlashKeep:
+		return trailingSlashKeep, nil
+	case trailingSlashAdd, trailingSlashRemove:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid trailing slash policy: %s", s)
+}
+
+// normalize lowercases the scheme and host, drops default ports and
+// fragments other than #! routes, removes tracking parameters, sorts
+// the query and applies the trailing slash policy
+func (n *urlNormalizer) normalize(u *url.URL) string {
+	norm := *u
+	norm.Scheme = strings.ToLower(u.Scheme)
+	norm.Host = strings.ToLower(u.Host)
+	if port := norm.Port(); (norm.Scheme == "http" && port == "80") || (norm.Scheme == "https" && port == "443") {
+		norm.Host = strings.TrimSuffix(norm.Host, ":"+port)
+	}
+	if !strings.HasPrefix(u.Fragment, "!") {
+		norm.Fragment = ""
+	}
+
+	query := u.Query()
+	for name := range query {
+		if n.strip(name) {
+			query.Del(name)
+		}
+	}
+	// Encode sorts by name
+	norm.RawQuery = query.Encode()
+
+	if norm.Path == "" {
+		norm.Path = "/"
+	}
+	switch n.trailingSlash {
+	case trailingSlashAdd:
+		// file names such as /index.html do not get a slash
+		if !strings.HasSuffix(norm.Path, "/") && !strings.Contains(path.Base(norm.Path), ".") {
+			norm.Path += "/"
+		}
+	case trailingSlashRemove:
+		if norm.Path = strings.TrimRight(norm.Path, "/"); norm.Path == "" {
+			norm.Path = "/"
+		}
+	}
+	return norm.String()
+}
+
+func (n *urlNormalizer) strip(name string) bool {
+	for _, p := range n.stripParams {
+		if strings.HasSuffix(p, "*") && strings.HasPrefix(name, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+		if name == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	n := &urlNormalizer{stripParams: defaultStripParams}
+	cases := map[string]string{
+		"https://netlify.com":                                 "https://netlify.com/",
+		"HTTPS://Netlify.COM:443/About":                       "https://netlify.com/About",
+		"http://netlify.com:80/":                              "http://netlify.com/",
+		"http://netlify.com:8080/":                            "http://netlify.com:8080/",
+		"https://netlify.com/?b=2&a=1&a=0":                    "https://netlify.com/?a=1&a=0&b=2",
+		"https://netlify.com/?utm_source=x&utm_medium=y&id=1": "https://netlify.com/?id=1",
+		"https://netlify.com/?gclid=abc":                      "https://netlify.com/",
+		"https://netlify.com/page#section":                    "https://netlify.com/page",
+		"https://netlify.com/#!/app/route":                    "https://netlify.com/#!/app/route",
+	}
+	for in, out := range cases {
+		u, err := url.Parse(in)
+		require.NoError(t, err)
+		assert.Equal(t, out, n.normalize(u), in)
+	}
+}
+
+func TestNormalizeTrailingSlash(t *testing.T) {
+	add := &urlNormalizer{trailingSlash: trailingSlashAdd}
+	remove := &urlNormalizer{trailingSlash: trailingSlashRemove}
+	cases := []struct {
+		in, added, removed string
+	}{
+		{"https://netlify.com/", "https://netlify.com/", "https://netlify.com/"},
+		{"https://netlify.com/blog", "https://netlify.com/blog/", "https://netlify.com/blog"},
+		{"https://netlify.com/blog/", "https://netlify.com/blog/", "https://netlify.com/blog"},
+		{"https://netlify.com/index.html", "https://netlify.com/index.html", "https://netlify.com/index.html"},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.in)
+		require.NoError(t, err)
+		assert.Equal(t, c.added, add.normalize(u), c.in)
+		assert.Equal(t, c.removed, remove.normalize(u), c.in)
+	}
+}
+
+func TestParseTrailingSlash(t *testing.T) {
+	policy, err := parseTrailingSlash("")
+	require.NoError(t, err)
+	assert.Equal(t, trailingSlashKeep, policy)
+
+	_, err = parseTrailingSlash("sometimes")
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brycekahle/prerender/cache"
+	"github.com/brycekahle/prerender/render"
+)
+
+// Per-request render options are read from these request headers.
+// Query parameters are not used because they belong to the origin URL.
+const (
+	timeoutHeader   = "X-Prerender-Timeout"
+	waitHeader      = "X-Prerender-Wait"
+	idleTimeHeader  = "X-Prerender-Idle-Time"
+	viewportHeader  = "X-Prerender-Viewport"
+	userAgentHeader = "X-Prerender-User-Agent"
+	headerHeader    = "X-Prerender-Header"
+	cookieHeader    = "X-Prerender-Cookie"
+	blockHeader     = "X-Prerender-Block"
+	selectorHeader  = "X-Prerender-Wait-Selector"
+	redirectsHeader = cache.FollowRedirectsHeader
+	deviceHeader    = cache.DeviceHeader
+)
+
+// customHeaders are the options that make a render specific to the request.
+// The cache only keys results by device and redirect handling, so renders
+// with any of these options are neither cached nor read from the cache.
+var customHeaders = []string{
+	timeoutHeader,
+	waitHeader,
+	idleTimeHeader,
+	viewportHeader,
+	userAgentHeader,
+	headerHeader,
+	cookieHeader,
+	blockHeader,
+	selectorHeader,
+}
+
+// customOptions reports whether the request sets any of the customHeaders
+func customOptions(r *http.Request) bool {
+	for _, h := range customHeaders {
+		if r.Header.Get(h) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// renderDefaults holds the options used for settings a request does not specify
+type renderDefaults struct {
+	options render.Options
+	// selectors are wait selectors by origin host name
+	selectors map[string]string
+	// detectDevice picks the device class from the User-Agent
+	// of requests that do not specify one
+	detectDevice bool
+}
+
+// parseSelectors parses per-host wait selectors in
+// host=selector;host=selector format
+func parseSelectors(v string) (map[string]string, error) {
+	selectors := map[string]string{}
+	for _, entry := range strings.Split(v, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		host, selector := strings.TrimSpace(parts[0]), ""
+		if len(parts) == 2 {
+			selector = strings.TrimSpace(parts[1])
+		}
+		if host == "" || selector == "" {
+			return nil, fmt.Errorf("invalid wait selector %q, expected host=selector", entry)
+		}
+		selectors[strings.ToLower(host)] = selector
+	}
+	return selectors, nil
+}
+
+// renderOptions builds the render options for a request,
+// starting from the defaults stored in the request context
+func renderOptions(r *http.Request) (render.Options, error) {
+	defaults := getDefaults(r.Context())
+	opts := defaults.options
+
+	if u, err := url.Parse(r.URL.Path); err == nil {
+		if selector, ok := defaults.selectors[strings.ToLower(u.Hostname())]; ok {
+			opts.WaitSelector = selector
+		}
+	}
+	if v := r.Header.Get(selectorHeader); v != "" {
+		opts.WaitSelector = v
+	}
+
+	if v := r.Header.Get(timeoutHeader); v != "" {
+		t, err := time.ParseDuration(v)
+		if err != nil || t <= 0 {
+			return opts, fmt.Errorf("invalid %s: %s", timeoutHeader, v)
+		}
+		opts.Timeout = t
+	}
+
+	if v := r.Header.Get(waitHeader); v != "" {
+		w, err := parseWait(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %s", waitHeader, v)
+		}
+		opts.Wait = w
+	}
+
+	if v := r.Header.Get(idleTimeHeader); v != "" {
+		t, err := time.ParseDuration(v)
+		if err != nil || t <= 0 {
+			return opts, fmt.Errorf("invalid %s: %s", idleTimeHeader, v)
+		}
+		opts.IdleTime = t
+	}
+
+	if v := r.Header.Get(viewportHeader); v != "" {
+		vp, err := parseViewport(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %s", viewportHeader, v)
+		}
+		opts.Viewport = vp
+	}
+
+	if v := r.Header.Get(redirectsHeader); v != "" {
+		follow, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %s", redirectsHeader, v)
+		}
+		opts.ReturnRedirects = !follow
+	}
+
+	if v := r.Header.Get(deviceHeader); v != "" {
+		d, err := parseDevice(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %s", deviceHeader, v)
+		}
+		opts.Device = d
+	} else if defaults.detectDevice {
+		opts.Device = detectDevice(r.UserAgent())
+	}
+
+	if v := r.Header.Get(userAgentHeader); v != "" {
+		opts.UserAgent = v
+	}
+
+	if values := r.Header[headerHeader]; len(values) > 0 {
+		// copy so the defaults are not modified
+		headers := make(map[string]string, len(opts.Headers)+len(values))
+		for k, v := range opts.Headers {
+			headers[k] = v
+		}
+		for _, v := range values {
+			parts := strings.SplitN(v, ":", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+				return opts, fmt.Errorf("invalid %s: %s", headerHeader, v)
+			}
+			headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		opts.Headers = headers
+	}
+
+	if values := r.Header[cookieHeader]; len(values) > 0 {
+		// reuse the Cookie header parser
+		opts.Cookies = (&http.Request{Header: http.Header{"Cookie": values}}).Cookies()
+	}
+
+	// cap the slice so appending never writes into the defaults
+	opts.BlockURLs = opts.BlockURLs[:len(opts.BlockURLs):len(opts.BlockURLs)]
+	for _, v := range r.Header[blockHeader] {
+		for _, pattern := range strings.Split(v, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				opts.BlockURLs = append(opts.BlockURLs, pattern)
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// parseWait parses the name of a wait strategy
+func parseWait(v string) (render.WaitStrategy, error) {
+	switch w := render.WaitStrategy(strings.ToLower(v)); w {
+	case render.WaitLoad, render.WaitDOMContentLoaded, render.WaitNetworkIdle:
+		return w, nil
+	}
+	return "", fmt.Errorf("unknown wait strategy %s", v)
+}
+
+// parseDevice parses the name of a device class
+func parseDevice(v string) (render.Device, error) {
+	switch d := render.Device(strings.ToLower(v)); d {
+	case render.DeviceDesktop, render.DeviceMobile, render.DeviceTablet:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown device %s", v)
+}
+
+// detectDevice guesses the device class from a User-Agent header
+func detectDevice(userAgent string) render.Device {
+	switch {
+	case strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "Tablet"):
+		return render.DeviceTablet
+	// Android tablets leave Mobile out of the user agent
+	case strings.Contains(userAgent, "Android") && !strings.Contains(userAgent, "Mobile"):
+		return render.DeviceTablet
+	case strings.Contains(userAgent, "Mobi"), strings.Contains(userAgent, "iPhone"),
+		strings.Contains(userAgent, "Opera Mini"):
+		return render.DeviceMobile
+	}
+	return render.DeviceDesktop
+}
+
+// parseViewport parses a viewport in WIDTHxHEIGHT format
+func parseViewport(v string) (render.Viewport, error) {
+	parts := strings.SplitN(strings.ToLower(v), "x", 2)
+	if len(parts) != 2 {
+		return render.Viewport{}, fmt.Errorf("viewport must be WIDTHxHEIGHT")
+	}
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return render.Viewport{}, fmt.Errorf("invalid viewport width")
+	}
+	height, err := strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return render.Viewport{}, fmt.Errorf("invalid viewport height")
+	}
+	return render.Viewport{Width: width, Height: height}, nil
+}
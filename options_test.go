@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brycekahle/prerender/render"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderOptions(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.Header.Set("X-Prerender-Timeout", "5s")
+	req.Header.Set("X-Prerender-Wait", "DOMContentLoaded")
+	req.Header.Set("X-Prerender-Viewport", "1280x800")
+	req.Header.Set("X-Prerender-User-Agent", "bot")
+	req.Header.Add("X-Prerender-Header", "Accept-Language: de")
+	req.Header.Add("X-Prerender-Header", "X-Site: one")
+	req.Header.Add("X-Prerender-Cookie", "session=abc; theme=dark")
+	req.Header.Add("X-Prerender-Block", "*.png, *.jpg")
+	req.Header.Add("X-Prerender-Block", "*.woff")
+	req.Header.Set("X-Prerender-Follow-Redirects", "false")
+
+	opts, err := renderOptions(req)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, opts.Timeout)
+	assert.Equal(t, render.WaitDOMContentLoaded, opts.Wait)
+	assert.Equal(t, render.Viewport{Width: 1280, Height: 800}, opts.Viewport)
+	assert.Equal(t, "bot", opts.UserAgent)
+	assert.Equal(t, map[string]string{"Accept-Language": "de", "X-Site": "one"}, opts.Headers)
+	require.Len(t, opts.Cookies, 2)
+	assert.Equal(t, "session", opts.Cookies[0].Name)
+	assert.Equal(t, "abc", opts.Cookies[0].Value)
+	assert.Equal(t, []string{"*.png", "*.jpg", "*.woff"}, opts.BlockURLs)
+	assert.True(t, opts.ReturnRedirects)
+}
+
+func TestInvalidRenderOptions(t *testing.T) {
+	for header, value := range map[string]string{
+		"X-Prerender-Timeout":          "soon",
+		"X-Prerender-Wait":             "forever",
+		"X-Prerender-Viewport":         "big",
+		"X-Prerender-Header":           "no colon",
+		"X-Prerender-Follow-Redirects": "maybe",
+		"X-Prerender-Device":           "watch",
+	} {
+		req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		handle(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode, header)
+	}
+}
+
+func TestRenderOptionsDefaults(t *testing.T) {
+	defaults := &renderDefaults{options: render.Options{
+		Wait:     render.WaitNetworkIdle,
+		IdleTime: time.Second,
+		Headers:  map[string]string{"X-Site": "one"},
+	}}
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req = req.WithContext(setDefaults(req.Context(), defaults))
+	req.Header.Set("X-Prerender-Idle-Time", "250ms")
+	req.Header.Add("X-Prerender-Header", "X-Other: two")
+
+	opts, err := renderOptions(req)
+	require.NoError(t, err)
+	assert.Equal(t, render.WaitNetworkIdle, opts.Wait)
+	assert.Equal(t, 250*time.Millisecond, opts.IdleTime)
+	assert.Equal(t, map[string]string{"X-Site": "one", "X-Other": "two"}, opts.Headers)
+	assert.Equal(t, map[string]string{"X-Site": "one"}, defaults.options.Headers)
+}
+
+func TestWaitSelectorOptions(t *testing.T) {
+	selectors, err := parseSelectors("netlify.com=#app [data-loaded]; example.com = main[data-x=y]")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"netlify.com": "#app [data-loaded]", "example.com": "main[data-x=y]"}, selectors)
+	defaults := &renderDefaults{selectors: selectors}
+
+	req := httptest.NewRequest("GET", "http://example.com/https://Netlify.com:443/", nil)
+	req.URL.Path = req.URL.Path[1:]
+	opts, err := renderOptions(req.WithContext(setDefaults(req.Context(), defaults)))
+	require.NoError(t, err)
+	assert.Equal(t, "#app [data-loaded]", opts.WaitSelector)
+
+	req.Header.Set("X-Prerender-Wait-Selector", "#other")
+	opts, err = renderOptions(req.WithContext(setDefaults(req.Context(), defaults)))
+	require.NoError(t, err)
+	assert.Equal(t, "#other", opts.WaitSelector)
+
+	_, err = parseSelectors("netlify.com")
+	assert.Error(t, err)
+}
+
+func TestDeviceOptions(t *testing.T) {
+	iphone := "Mozilla/5.0 (iPhone; CPU iPhone OS 11_0 like Mac OS X) AppleWebKit/604.1.38 (KHTML, like Gecko) Version/11.0 Mobile/15A372 Safari/604.1"
+	req := httptest.NewRequest("GET", "http://example.com/https://netlify.com/", nil)
+	req.Header.Set("User-Agent", iphone)
+
+	opts, err := renderOptions(req)
+	require.NoError(t, err)
+	assert.Equal(t, render.Device(""), opts.Device)
+
+	req = req.WithContext(setDefaults(req.Context(), &renderDefaults{detectDevice: true}))
+	opts, err = renderOptions(req)
+	require.NoError(t, err)
+	assert.Equal(t, render.DeviceMobile, opts.Device)
+
+	req.Header.Set("X-Prerender-Device", "Tablet")
+	opts, err = renderOptions(req)
+	require.NoError(t, err)
+	assert.Equal(t, render.DeviceTablet, opts.Device)
+}
+
+func TestDetectDevice(t *testing.T) {
+	for userAgent, device := range map[string]render.Device{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_13_1) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/62.0.3202.94 Safari/537.36":                 render.DeviceDesktop,
+		"Mozilla/5.0 (Linux; Android 7.0; Nexus 5X Build/NRD90M) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/61.0.3163.100 Mobile Safari/537.36": render.DeviceMobile,
+		"Mozilla/5.0 (Linux; Android 7.0; Pixel C Build/NRD90M) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/61.0.3163.98 Safari/537.36":          render.DeviceTablet,
+		"Mozilla/5.0 (iPad; CPU OS 11_0 like Mac OS X) AppleWebKit/604.1.34 (KHTML, like Gecko) Version/11.0 Mobile/15A5341f Safari/604.1":         render.DeviceTablet,
+		"Opera/9.80 (J2ME/MIDP; Opera Mini/9.80 (S60; SymbOS; Opera Mobi/23.348; U; en) Presto/2.5.25 Version/10.54":                               render.DeviceMobile,
+		"": render.DeviceDesktop,
+	} {
+		assert.Equal(t, device, detectDevice(userAgent), userAgent)
+	}
+}
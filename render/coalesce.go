@@ -0,0 +1,95 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+type coalescingRenderer struct {
+	Renderer
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall is a render shared by concurrent identical requests
+type coalescedCall struct {
+	done    chan struct{}
+	res     *Result
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Coalesce wraps the renderer so concurrent renders of the same URL with the
+// same options result in a single render, whose result is returned to all
+// callers. The render is only canceled once every caller has given up on it.
+func Coalesce(r Renderer) Renderer {
+	return &coalescingRenderer{Renderer: r, calls: map[string]*coalescedCall{}}
+}
+
+func (r *coalescingRenderer) Render(ctx context.Context, url string, opts Options) (*Result, error) {
+	key, err := Key(url, opts)
+	if err != nil {
+		return r.Renderer.Render(ctx, url, opts)
+	}
+
+	r.mu.Lock()
+	c, ok := r.calls[key]
+	if !ok {
+		// the render must outlive the context of the request starting it
+		renderCtx, cancel := context.WithCancel(context.Background())
+		c = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		r.calls[key] = c
+		go r.render(renderCtx, key, c, url, opts)
+	}
+	c.waiters++
+	r.mu.Unlock()
+
+	select {
+	case <-c.done:
+		if c.err != nil {
+			return nil, c.err
+		}
+		// callers may modify their result
+		res := *c.res
+		return &res, nil
+	case <-ctx.Done():
+		r.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			r.forget(key, c)
+		}
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (r *coalescingRenderer) render(ctx context.Context, key string, c *coalescedCall, url string, opts Options) {
+	c.res, c.err = r.Renderer.Render(ctx, url, opts)
+	c.cancel()
+
+	r.mu.Lock()
+	r.forget(key, c)
+	r.mu.Unlock()
+	close(c.done)
+}
+
+// forget stops new callers from joining the call. r.mu must be held.
+func (r *coalescingRenderer) forget(key string, c *coalescedCall) {
+	if r.calls[key] == c {
+		delete(r.calls, key)
+	}
+}
+
+// Key identifies a render of url with opts. Renders with the same key are
+// interchangeable.
+func Key(url string, opts Options) (string, error) {
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	return url + " " + string(b), nil
+}
@@ -0,0 +1,95 @@
+package render
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type renderResult struct {
+	res *Result
+	err error
+}
+
+func renderAsync(r Renderer, ctx context.Context, url string, opts Options) chan renderResult {
+	done := make(chan renderResult, 1)
+	go func() {
+		res, err := r.Render(ctx, url, opts)
+		done <- renderResult{res, err}
+	}()
+	return done
+}
+
+func TestCoalesce(t *testing.T) {
+	b := &blockingRenderer{started: make(chan string, 3), finish: make(chan struct{})}
+	c := Coalesce(b).(*coalescingRenderer)
+
+	first := renderAsync(c, context.Background(), "one", Options{})
+	assert.Equal(t, "one", <-b.started)
+	second := renderAsync(c, context.Background(), "one", Options{})
+	other := renderAsync(c, context.Background(), "one", Options{Wait: WaitNetworkIdle})
+	assert.Equal(t, "one", <-b.started)
+
+	// the second request must join the render before it finishes
+	key, err := Key("one", Options{})
+	require.NoError(t, err)
+	for waiting := false; !waiting; {
+		c.mu.Lock()
+		waiting = c.calls[key].waiters == 2
+		c.mu.Unlock()
+	}
+	close(b.finish)
+	for _, done := range []chan renderResult{first, second, other} {
+		r := <-done
+		require.NoError(t, r.err)
+		assert.Equal(t, "one", r.res.URL)
+	}
+	assert.Len(t, b.started, 0)
+}
+
+func TestCoalesceLeaderCanceled(t *testing.T) {
+	b := &blockingRenderer{started: make(chan string, 2), finish: make(chan struct{})}
+	c := Coalesce(b).(*coalescingRenderer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := renderAsync(c, ctx, "one", Options{})
+	assert.Equal(t, "one", <-b.started)
+	follower := renderAsync(c, context.Background(), "one", Options{})
+
+	// the follower keeps the render going
+	key, err := Key("one", Options{})
+	require.NoError(t, err)
+	for waiting := false; !waiting; {
+		c.mu.Lock()
+		waiting = c.calls[key].waiters == 2
+		c.mu.Unlock()
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, (<-leader).err)
+
+	close(b.finish)
+	r := <-follower
+	require.NoError(t, r.err)
+	assert.Equal(t, "one", r.res.URL)
+}
+
+func TestCoalesceAllCanceled(t *testing.T) {
+	b := &blockingRenderer{started: make(chan string, 2), finish: make(chan struct{})}
+	c := Coalesce(b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	res, err := c.Render(ctx, "one", Options{})
+	assert.Nil(t, res)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, "one", <-b.started)
+
+	// a new request starts a new render instead of joining the canceled one
+	next := renderAsync(c, context.Background(), "one", Options{})
+	assert.Equal(t, "one", <-b.started)
+	close(b.finish)
+	require.NoError(t, (<-next).err)
+}
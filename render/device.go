@@ -0,0 +1,33 @@
+package render
+
+// Device is the class of device a page is rendered for
+type Device string
+
+const (
+	// DeviceDesktop renders pages in the default desktop window
+	DeviceDesktop Device = "desktop"
+	// DeviceMobile emulates a phone
+	DeviceMobile Device = "mobile"
+	// DeviceTablet emulates a tablet
+	DeviceTablet Device = "tablet"
+)
+
+type devicePreset struct {
+	viewport    Viewport
+	scaleFactor float64
+	userAgent   string
+}
+
+// devicePresets are the emulation settings of devices other than desktop
+var devicePresets = map[Device]devicePreset{
+	DeviceMobile: {
+		viewport:    Viewport{Width: 412, Height: 732},
+		scaleFactor: 2.625,
+		userAgent:   "Mozilla/5.0 (Linux; Android 7.0; Nexus 5X Build/NRD90M) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/61.0.3163.100 Mobile Safari/537.36",
+	},
+	DeviceTablet: {
+		viewport:    Viewport{Width: 768, Height: 1024},
+		scaleFactor: 2,
+		userAgent:   "Mozilla/5.0 (iPad; CPU OS 11_0 like Mac OS X) AppleWebKit/604.1.34 (KHTML, like Gecko) Version/11.0 Mobile/15A5341f Safari/604.1",
+	},
+}
@@ -0,0 +1,71 @@
+package render
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// metaScript collects the prerender-status-code and prerender-header meta
+// tags, which let single page apps respond with a status other than 200
+const metaScript = `JSON.stringify({
+	status: Array.prototype.map.call(document.querySelectorAll('meta[name="prerender-status-code"]'), function(m) { return m.content; }),
+	headers: Array.prototype.map.call(document.querySelectorAll('meta[name="prerender-header"]'), function(m) { return m.content; })
+})`
+
+type metaTags struct {
+	Status  []string `json:"status"`
+	Headers []string `json:"headers"`
+}
+
+// readMetaTags applies the prerender meta tags of the page to the result
+func readMetaTags(scripts *scriptRunner, frameID string, res *Result) error {
+	v, err := scripts.eval(frameID, metaScript)
+	if err != nil {
+		return errors.Wrap(err, "reading meta tags failed")
+	}
+	s, _ := v.(string)
+	var tags metaTags
+	if err := json.Unmarshal([]byte(s), &tags); err != nil {
+		return errors.Wrap(err, "reading meta tags failed")
+	}
+	tags.apply(res)
+	return nil
+}
+
+// apply sets the status from the first valid status code tag and adds
+// headers given as "Name: value", replacing origin headers of the same name.
+// Invalid tags are ignored.
+func (tags metaTags) apply(res *Result) {
+	for _, s := range tags.Status {
+		if status, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && status >= 100 && status <= 599 {
+			res.Status = status
+			break
+		}
+	}
+	replaced := map[string]bool{}
+	for _, h := range tags.Headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		if res.Headers == nil {
+			res.Headers = http.Header{}
+		}
+		name := http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))
+		if !replaced[name] {
+			res.Headers.Del(name)
+			replaced[name] = true
+		}
+		res.Headers.Add(name, strings.TrimSpace(parts[1]))
+		if name == "Cache-Control" || name == "Expires" {
+			if res.CacheHeaders == nil {
+				res.CacheHeaders = http.Header{}
+			}
+			res.CacheHeaders[name] = append([]string(nil), res.Headers[name]...)
+		}
+	}
+}
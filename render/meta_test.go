@@ -0,0 +1,51 @@
+package render
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetaTagsApply(t *testing.T) {
+	res := Result{Status: http.StatusOK}
+	metaTags{
+		Status:  []string{"abc", " 301 "},
+		Headers: []string{"Location: https://netlify.com/new", "invalid", ": empty"},
+	}.apply(&res)
+
+	assert.Equal(t, http.StatusMovedPermanently, res.Status)
+	assert.Equal(t, http.Header{"Location": {"https://netlify.com/new"}}, res.Headers)
+}
+
+func TestMetaTagsCacheHeaders(t *testing.T) {
+	res := Result{Status: http.StatusOK, CacheHeaders: http.Header{"Cache-Control": {"max-age=600"}}}
+	metaTags{Headers: []string{"Cache-Control: no-store"}}.apply(&res)
+
+	assert.Equal(t, http.Header{"Cache-Control": {"no-store"}}, res.Headers)
+	assert.Equal(t, http.Header{"Cache-Control": {"no-store"}}, res.CacheHeaders)
+}
+
+func TestMetaTagsInvalidStatus(t *testing.T) {
+	res := Result{Status: http.StatusOK}
+	metaTags{Status: []string{"999"}}.apply(&res)
+
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.Nil(t, res.Headers)
+}
+
+func TestMetaTagsReplaceOriginHeaders(t *testing.T) {
+	res := Result{Status: http.StatusOK, Headers: http.Header{
+		"Link":         {"<https://netlify.com/a>; rel=canonical"},
+		"Content-Type": {"text/html"},
+	}}
+	metaTags{Headers: []string{
+		"link: <https://netlify.com/b>; rel=canonical",
+		"Link: <https://netlify.com/b.css>; rel=preload",
+	}}.apply(&res)
+
+	assert.Equal(t, http.Header{
+		"Link":         {"<https://netlify.com/b>; rel=canonical", "<https://netlify.com/b.css>; rel=preload"},
+		"Content-Type": {"text/html"},
+	}, res.Headers)
+}
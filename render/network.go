@@ -0,0 +1,207 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wirepair/gcd"
+	"github.com/wirepair/gcd/gcdapi"
+)
+
+// DefaultIdleTime is how long the network must be quiet before
+// a page rendered with WaitNetworkIdle is captured
+const DefaultIdleTime = 500 * time.Millisecond
+
+// idlePollInterval is how often the network is checked while requests are pending
+const idlePollInterval = 50 * time.Millisecond
+
+// networkTracker follows the requests a tab makes
+type networkTracker struct {
+	frameID    string
+	redirected chan struct{}
+
+	mu           sync.Mutex
+	pending      map[string]struct{}
+	lastActivity time.Time
+	url          string
+	status       int
+	header       http.Header
+	redirects    []Redirect
+}
+
+// newNetworkTracker follows the requests of the tab, recording the response
+// to the document request of the main frame. It must be created before the
+// Network domain is enabled.
+func newNetworkTracker(tab *gcd.ChromeTarget, frameID string) *networkTracker {
+	n := &networkTracker{
+		frameID:      frameID,
+		redirected:   make(chan struct{}, 1),
+		pending:      map[string]struct{}{},
+		lastActivity: time.Now(),
+	}
+
+	tab.Subscribe("Network.requestWillBeSent", func(target *gcd.ChromeTarget, v []byte) {
+		event := &gcdapi.NetworkRequestWillBeSentEvent{}
+		if err := json.Unmarshal(v, event); err != nil {
+			return
+		}
+		p := event.Params
+		n.started(p.RequestId)
+		// document requests share their id with the loader
+		isDocument := p.Type == "Document" || p.RequestId == p.LoaderId
+		if p.FrameId == n.frameID && isDocument && p.RedirectResponse != nil {
+			n.documentRedirected(p.RedirectResponse, p.Request.Url)
+		}
+	})
+	tab.Subscribe("Network.responseReceived", func(target *gcd.ChromeTarget, v []byte) {
+		event := &gcdapi.NetworkResponseReceivedEvent{}
+		if err := json.Unmarshal(v, event); err != nil {
+			return
+		}
+		// subresources and iframes must not affect the page status
+		if event.Params.FrameId == n.frameID && event.Params.Type == "Document" {
+			n.documentReceived(event.Params.Response)
+		}
+	})
+	tab.Subscribe("Network.loadingFinished", func(target *gcd.ChromeTarget, v []byte) {
+		event := &gcdapi.NetworkLoadingFinishedEvent{}
+		if err := json.Unmarshal(v, event); err == nil {
+			n.finished(event.Params.RequestId)
+		}
+	})
+	tab.Subscribe("Network.loadingFailed", func(target *gcd.ChromeTarget, v []byte) {
+		event := &gcdapi.NetworkLoadingFailedEvent{}
+		if err := json.Unmarshal(v, event); err == nil {
+			n.finished(event.Params.RequestId)
+		}
+	})
+	return n
+}
+
+func (n *networkTracker) started(id string) {
+	n.mu.Lock()
+	n.pending[id] = struct{}{}
+	n.lastActivity = time.Now()
+	n.mu.Unlock()
+}
+
+func (n *networkTracker) finished(id string) {
+	n.mu.Lock()
+	delete(n.pending, id)
+	n.lastActivity = time.Now()
+	n.mu.Unlock()
+}
+
+func (n *networkTracker) documentRedirected(r *gcdapi.NetworkResponse, location string) {
+	n.mu.Lock()
+	n.redirects = append(n.redirects, Redirect{URL: r.Url, Status: int(r.Status), Location: location})
+	n.mu.Unlock()
+	select {
+	case n.redirected <- struct{}{}:
+	default:
+	}
+}
+
+func (n *networkTracker) documentReceived(r *gcdapi.NetworkResponse) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.url = r.Url
+	n.status = int(r.Status)
+	n.header = http.Header{}
+	for name, value := range r.Headers {
+		s, _ := value.(string)
+		// repeated headers are joined by newlines
+		for _, v := range strings.Split(s, "\n") {
+			n.header.Add(name, v)
+		}
+	}
+}
+
+// document returns the URL, status and headers of the main document response.
+// The status is 0 if no response was received.
+func (n *networkTracker) document() (string, int, http.Header) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.url, n.status, n.header
+}
+
+// forwardHeaders copies the named headers from the origin response
+func forwardHeaders(header http.Header, names []string) http.Header {
+	if names == nil {
+		names = DefaultForwardHeaders
+	}
+	forwarded := http.Header{}
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if values, ok := header[name]; ok {
+			forwarded[name] = append([]string(nil), values...)
+		}
+	}
+	if v := forwarded.Get("Content-Type"); v != "" {
+		if contentType := utf8ContentType(v); contentType != "" {
+			forwarded.Set("Content-Type", contentType)
+		} else {
+			forwarded.Del("Content-Type")
+		}
+	}
+	return forwarded
+}
+
+// cacheHeaders copies the headers deciding how long the origin
+// response may be cached, whether or not they are forwarded
+func cacheHeaders(header http.Header) http.Header {
+	cached := http.Header{}
+	for _, name := range []string{"Cache-Control", "Expires"} {
+		if values, ok := header[name]; ok {
+			cached[name] = append([]string(nil), values...)
+		}
+	}
+	return cached
+}
+
+// utf8ContentType returns the content type with its charset replaced by
+// utf-8, since the rendered HTML is always serialized as UTF-8.
+// It returns an empty string if the content type is invalid.
+func utf8ContentType(v string) string {
+	mediaType, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return ""
+	}
+	params["charset"] = "utf-8"
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// redirectChain returns the redirects followed by the main document
+func (n *networkTracker) redirectChain() []Redirect {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Redirect(nil), n.redirects...)
+}
+
+// waitIdle returns once no requests have been pending for the quiet period
+func (n *networkTracker) waitIdle(ctx context.Context, quiet time.Duration) error {
+	for {
+		n.mu.Lock()
+		pending := len(n.pending)
+		wait := quiet - time.Since(n.lastActivity)
+		n.mu.Unlock()
+
+		if pending == 0 && wait <= 0 {
+			return nil
+		}
+		if pending > 0 {
+			wait = idlePollInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
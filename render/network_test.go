@@ -0,0 +1,95 @@
+package render
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wirepair/gcd/gcdapi"
+)
+
+func TestNetworkWaitIdle(t *testing.T) {
+	n := &networkTracker{pending: map[string]struct{}{}, lastActivity: time.Now()}
+	n.started("1")
+	time.AfterFunc(50*time.Millisecond, func() { n.finished("1") })
+
+	start := time.Now()
+	assert.NoError(t, n.waitIdle(context.Background(), 20*time.Millisecond))
+	assert.True(t, time.Since(start) >= 70*time.Millisecond)
+}
+
+func TestNetworkWaitIdleTimeout(t *testing.T) {
+	n := &networkTracker{pending: map[string]struct{}{}, lastActivity: time.Now()}
+	n.started("1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, n.waitIdle(ctx, time.Millisecond))
+}
+
+func TestNetworkDocumentReceived(t *testing.T) {
+	n := &networkTracker{pending: map[string]struct{}{}}
+	n.documentReceived(&gcdapi.NetworkResponse{
+		Status: 200,
+		Headers: map[string]interface{}{
+			"etag":       "documentetag",
+			"Set-Cookie": "a=1\nb=2",
+		},
+	})
+
+	_, status, header := n.document()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "documentetag", header.Get("Etag"))
+	assert.Equal(t, []string{"a=1", "b=2"}, header["Set-Cookie"])
+}
+
+func TestNetworkDocumentRedirected(t *testing.T) {
+	n := &networkTracker{pending: map[string]struct{}{}, redirected: make(chan struct{}, 1)}
+	n.documentRedirected(&gcdapi.NetworkResponse{Url: "http://a.com/", Status: 301}, "http://b.com/")
+	n.documentRedirected(&gcdapi.NetworkResponse{Url: "http://b.com/", Status: 302}, "http://c.com/")
+
+	assert.Len(t, n.redirected, 1)
+	assert.Equal(t, []Redirect{
+		{URL: "http://a.com/", Status: 301, Location: "http://b.com/"},
+		{URL: "http://b.com/", Status: 302, Location: "http://c.com/"},
+	}, n.redirectChain())
+}
+
+func TestForwardHeaders(t *testing.T) {
+	header := http.Header{
+		"Content-Type": {"text/html"},
+		"X-Robots-Tag": {"noindex"},
+		"Set-Cookie":   {"a=1"},
+	}
+
+	assert.Equal(t, http.Header{
+		"Content-Type": {"text/html; charset=utf-8"},
+		"X-Robots-Tag": {"noindex"},
+	}, forwardHeaders(header, nil))
+	assert.Equal(t, http.Header{"Set-Cookie": {"a=1"}}, forwardHeaders(header, []string{"set-cookie"}))
+	assert.Empty(t, forwardHeaders(header, []string{}))
+}
+
+func TestCacheHeaders(t *testing.T) {
+	header := http.Header{
+		"Cache-Control": {"no-store"},
+		"Expires":       {"0"},
+		"X-Robots-Tag":  {"noindex"},
+	}
+	assert.Equal(t, http.Header{"Cache-Control": {"no-store"}, "Expires": {"0"}}, cacheHeaders(header))
+	assert.Empty(t, forwardHeaders(header, []string{"Link"}))
+}
+
+func TestForwardContentType(t *testing.T) {
+	for contentType, expected := range map[string]string{
+		"text/html":                          "text/html; charset=utf-8",
+		"text/html; charset=ISO-8859-1":      "text/html; charset=utf-8",
+		"application/xhtml+xml;charset=utf8": "application/xhtml+xml; charset=utf-8",
+	} {
+		header := http.Header{"Content-Type": {contentType}}
+		assert.Equal(t, expected, forwardHeaders(header, nil).Get("Content-Type"), contentType)
+	}
+	assert.Empty(t, forwardHeaders(http.Header{"Content-Type": {"text/html; charset"}}, nil))
+}
@@ -0,0 +1,131 @@
+package render
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wirepair/gcd"
+	"github.com/wirepair/gcd/gcdapi"
+)
+
+// WaitStrategy determines when a page is considered rendered
+type WaitStrategy string
+
+const (
+	// WaitLoad captures the page when the "load" event fires
+	WaitLoad WaitStrategy = "load"
+	// WaitDOMContentLoaded captures the page when the "DOMContentLoaded" event fires
+	WaitDOMContentLoaded WaitStrategy = "domcontentloaded"
+	// WaitNetworkIdle captures the page after the "load" event once no
+	// requests have been in flight for the idle time
+	WaitNetworkIdle WaitStrategy = "networkidle"
+)
+
+// Viewport is the size of the window a page is rendered in
+type Viewport struct {
+	Width  int
+	Height int
+}
+
+// Options configures a single render. Zero values use the renderer's defaults.
+type Options struct {
+	Timeout time.Duration
+	Wait    WaitStrategy
+	// IdleTime is the quiet period required by WaitNetworkIdle
+	IdleTime time.Duration
+	// WaitSelector delays capturing the page until an element
+	// matching the CSS selector exists
+	WaitSelector string
+	// Device emulates the viewport, user agent and touch input of a class
+	// of device. Viewport and UserAgent override the device's settings.
+	Device    Device
+	Viewport  Viewport
+	UserAgent string
+	Headers   map[string]string
+	Cookies   []*http.Cookie
+	// ReturnRedirects stops at the first redirect from the origin and returns
+	// it as the result instead of rendering the redirect target
+	ReturnRedirects bool
+	// BlockURLs are URL patterns, which may contain * wildcards,
+	// the page is not allowed to load
+	BlockURLs []string
+	// ForwardHeaders are the origin response headers copied to the result.
+	// A nil slice forwards DefaultForwardHeaders, an empty one forwards none.
+	ForwardHeaders []string
+}
+
+// DefaultForwardHeaders are the origin response headers copied
+// to the result unless Options.ForwardHeaders is set
+var DefaultForwardHeaders = []string{
+	"Content-Type",
+	"Cache-Control",
+	"Last-Modified",
+	"Link",
+	"X-Robots-Tag",
+	"Content-Language",
+}
+
+// applyOptions configures the tab before navigating
+func applyOptions(tab *gcd.ChromeTarget, opts Options) error {
+	v, userAgent, scaleFactor := opts.Viewport, opts.UserAgent, 1.0
+	preset, mobile := devicePresets[opts.Device]
+	if mobile {
+		if v.Width <= 0 || v.Height <= 0 {
+			v = preset.viewport
+		}
+		if userAgent == "" {
+			userAgent = preset.userAgent
+		}
+		scaleFactor = preset.scaleFactor
+	}
+
+	if v.Width > 0 && v.Height > 0 {
+		orientation := &gcdapi.EmulationScreenOrientation{Type: "landscapePrimary", Angle: 90}
+		if v.Height > v.Width {
+			orientation = &gcdapi.EmulationScreenOrientation{Type: "portraitPrimary", Angle: 0}
+		}
+		if _, err := tab.Emulation.SetDeviceMetricsOverride(v.Width, v.Height, scaleFactor, mobile, false, 1, 0, 0, v.Width, v.Height, 0, 0, orientation); err != nil {
+			return errors.Wrap(err, "setting viewport failed")
+		}
+	}
+	if mobile {
+		if _, err := tab.Emulation.SetTouchEmulationEnabled(true, "mobile"); err != nil {
+			return errors.Wrap(err, "enabling touch emulation failed")
+		}
+	}
+	if userAgent != "" {
+		if _, err := tab.Network.SetUserAgentOverride(userAgent); err != nil {
+			return errors.Wrap(err, "setting user agent failed")
+		}
+	}
+	// cookies are sent as a header because the cookie store
+	// is shared by all tabs of the Chrome process
+	var cookies []string
+	headers := make(map[string]interface{}, len(opts.Headers)+1)
+	for k, v := range opts.Headers {
+		if http.CanonicalHeaderKey(k) == "Cookie" {
+			cookies = append(cookies, v)
+			continue
+		}
+		headers[k] = v
+	}
+	for _, c := range opts.Cookies {
+		cookies = append(cookies, c.Name+"="+c.Value)
+	}
+	if len(cookies) > 0 {
+		headers["Cookie"] = strings.Join(cookies, "; ")
+	}
+	if len(headers) > 0 {
+		if _, err := tab.Network.SetExtraHTTPHeaders(headers); err != nil {
+			return errors.Wrap(err, "setting extra headers failed")
+		}
+	}
+	if len(opts.BlockURLs) > 0 {
+		if _, err := tab.Network.SetBlockedURLs(opts.BlockURLs); err != nil {
+			return errors.Wrap(err, "blocking urls failed")
+		}
+	}
+	return nil
+}
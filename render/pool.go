@@ -0,0 +1,102 @@
+package render
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type pooledRenderer struct {
+	renderers []Renderer
+
+	mu       sync.Mutex
+	inflight []int
+}
+
+// NewRendererPool launches size headless Google Chrome instances on
+// consecutive debugging ports starting at basePort. Renders are sent to
+// the instance with the fewest renders in flight.
+func NewRendererPool(size, basePort int) (Renderer, error) {
+	if size < 1 {
+		return nil, errors.New("renderer pool size must be at least 1")
+	}
+
+	p := &pooledRenderer{inflight: make([]int, size)}
+	for i := 0; i < size; i++ {
+		r, err := newChromeRenderer(os.TempDir(), basePort+i)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.renderers = append(p.renderers, r)
+	}
+	return p, nil
+}
+
+// acquire returns the index of the least loaded renderer
+// and counts a render against it
+func (p *pooledRenderer) acquire() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	min := 0
+	for i, n := range p.inflight {
+		if n < p.inflight[min] {
+			min = i
+		}
+	}
+	p.inflight[min]++
+	return min
+}
+
+func (p *pooledRenderer) release(i int) {
+	p.mu.Lock()
+	p.inflight[i]--
+	p.mu.Unlock()
+}
+
+func (p *pooledRenderer) Render(ctx context.Context, url string, opts Options) (*Result, error) {
+	i := p.acquire()
+	defer p.release(i)
+	return p.renderers[i].Render(ctx, url, opts)
+}
+
+func (p *pooledRenderer) SetPageLoadTimeout(t time.Duration) {
+	for _, r := range p.renderers {
+		r.SetPageLoadTimeout(t)
+	}
+}
+
+// SetTabLimit applies the limits to each Chrome instance in the pool
+func (p *pooledRenderer) SetTabLimit(max, queue int, wait time.Duration) {
+	for _, r := range p.renderers {
+		r.SetTabLimit(max, queue, wait)
+	}
+}
+
+// SetRecyclePolicy applies the policy to each Chrome instance in the pool
+func (p *pooledRenderer) SetRecyclePolicy(policy RecyclePolicy) {
+	for _, r := range p.renderers {
+		r.SetRecyclePolicy(policy)
+	}
+}
+
+func (p *pooledRenderer) Stats() Stats {
+	var stats Stats
+	for _, r := range p.renderers {
+		s := r.Stats()
+		stats.ActiveTabs += s.ActiveTabs
+		stats.Queued += s.Queued
+		stats.Restarts += s.Restarts
+		stats.Recycles += s.Recycles
+	}
+	return stats
+}
+
+func (p *pooledRenderer) Close() {
+	for _, r := range p.renderers {
+		r.Close()
+	}
+}
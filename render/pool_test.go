@@ -0,0 +1,73 @@
+package render
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type blockingRenderer struct {
+	started chan string
+	finish  chan struct{}
+}
+
+func (b *blockingRenderer) Render(ctx context.Context, url string, opts Options) (*Result, error) {
+	b.started <- url
+	select {
+	case <-b.finish:
+		return &Result{URL: url}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+func (b *blockingRenderer) SetPageLoadTimeout(time.Duration)    {}
+func (b *blockingRenderer) SetTabLimit(int, int, time.Duration) {}
+func (b *blockingRenderer) SetRecyclePolicy(RecyclePolicy)      {}
+func (b *blockingRenderer) Stats() Stats                        { return Stats{} }
+func (b *blockingRenderer) Close()                              {}
+
+func TestPoolLeastLoaded(t *testing.T) {
+	a := &blockingRenderer{started: make(chan string, 2), finish: make(chan struct{})}
+	b := &blockingRenderer{started: make(chan string, 2), finish: make(chan struct{})}
+	p := &pooledRenderer{renderers: []Renderer{a, b}, inflight: make([]int, 2)}
+
+	go p.Render(context.Background(), "one", Options{})
+	assert.Equal(t, "one", <-a.started)
+	go p.Render(context.Background(), "two", Options{})
+	assert.Equal(t, "two", <-b.started)
+
+	close(a.finish)
+	for idle := false; !idle; {
+		p.mu.Lock()
+		idle = p.inflight[0] == 0
+		p.mu.Unlock()
+	}
+	go p.Render(context.Background(), "three", Options{})
+	assert.Equal(t, "three", <-a.started)
+	close(b.finish)
+}
+
+func TestPoolRender(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, "<body>data</body>")
+	}))
+	defer server.Close()
+
+	p, err := NewRendererPool(2, 9230)
+	require.NoError(t, err)
+	defer p.Close()
+
+	for i := 0; i < 2; i++ {
+		res, err := p.Render(context.Background(), server.URL, Options{})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.Status)
+		assert.Equal(t, "<html><head></head><body>data</body></html>", res.HTML)
+	}
+}
@@ -0,0 +1,217 @@
+package render
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wirepair/gcd"
+)
+
+// ErrChromeTerminated is returned for renders that were in progress
+// when the Chrome process exited unexpectedly
+var ErrChromeTerminated = errors.New("chrome terminated during render")
+
+// restartBackoff is the time to wait before relaunching a Chrome
+// process that exited shortly after starting
+const restartBackoff = time.Second
+
+// recycleCheckInterval is how often the age and memory
+// of the current Chrome process are checked
+const recycleCheckInterval = 10 * time.Second
+
+var chromeFlags = []string{"--headless", "--disable-gpu"}
+
+// RecyclePolicy controls when a Chrome process is replaced by a fresh one.
+// Zero values disable the corresponding limit.
+type RecyclePolicy struct {
+	// MaxRenders is the number of renders after which the process is recycled
+	MaxRenders int
+	// MaxAge is how long a process may run before it is recycled
+	MaxAge time.Duration
+	// MaxRSS is the resident memory in bytes of the process and its
+	// children above which the process is recycled
+	MaxRSS int64
+}
+
+type chromeProcess struct {
+	debugger   *gcd.Gcd
+	port       int
+	userDir    string
+	started    time.Time
+	terminated chan struct{}
+	once       sync.Once
+
+	renders   int64
+	recycling int32
+	inflight  sync.WaitGroup
+}
+
+func (p *chromeProcess) dead() bool {
+	select {
+	case <-p.terminated:
+		return true
+	default:
+		return false
+	}
+}
+
+// start launches a new Chrome process with the renderer's settings.
+// If the process exits while it is the renderer's current process,
+// it is relaunched.
+func (r *chromeRenderer) start(port int) (*chromeProcess, error) {
+	// every process needs its own profile, otherwise Chrome hands
+	// the launch off to a process already using the directory
+	dir, err := ioutil.TempDir(r.userDir, "prerender-chrome-")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating chrome user directory failed")
+	}
+
+	p := &chromeProcess{
+		debugger:   gcd.NewChromeDebugger(),
+		port:       port,
+		userDir:    dir,
+		started:    time.Now(),
+		terminated: make(chan struct{}),
+	}
+	p.debugger.SetTerminationHandler(func(reason string) {
+		log.Printf("chrome termination: %s\n", reason)
+		p.once.Do(func() { close(p.terminated) })
+		os.RemoveAll(p.userDir)
+		r.restart(p)
+	})
+	p.debugger.AddFlags(chromeFlags)
+	p.debugger.StartProcess(r.chromePath, dir, strconv.Itoa(port))
+	return p, nil
+}
+
+func (r *chromeRenderer) restart(dead *chromeProcess) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.process != dead {
+		return
+	}
+	if time.Since(dead.started) < restartBackoff {
+		time.Sleep(restartBackoff)
+	}
+	p, err := r.start(dead.port)
+	if err != nil {
+		log.Printf("chrome restart failed: %s\n", err)
+		return
+	}
+	r.process = p
+	r.restarts++
+	log.Printf("chrome restarted on port %d, %d restarts\n", p.port, r.restarts)
+}
+
+func (r *chromeRenderer) current() *chromeProcess {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.process
+}
+
+// acquireProcess returns the running Chrome process and counts a render
+// against it, waiting for a restart to complete if one is in progress
+func (r *chromeRenderer) acquireProcess() *chromeProcess {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p := r.process
+	p.inflight.Add(1)
+	return p
+}
+
+func (r *chromeRenderer) releaseProcess(p *chromeProcess) {
+	renders := atomic.AddInt64(&p.renders, 1)
+	p.inflight.Done()
+
+	r.mu.RLock()
+	max := r.recycle.MaxRenders
+	r.mu.RUnlock()
+	if max > 0 && renders >= int64(max) {
+		go r.replace(p, "render limit reached")
+	}
+}
+
+// replace launches a fresh Chrome process, switches new renders to it and
+// shuts down the old process once its in-flight renders have finished
+func (r *chromeRenderer) replace(old *chromeProcess, reason string) {
+	if !atomic.CompareAndSwapInt32(&old.recycling, 0, 1) {
+		return
+	}
+
+	// the old process still holds its port while draining
+	port, err := freePort()
+	if err != nil {
+		log.Printf("chrome recycle failed: %s\n", err)
+		atomic.StoreInt32(&old.recycling, 0)
+		return
+	}
+	fresh, err := r.start(port)
+	if err != nil {
+		log.Printf("chrome recycle failed: %s\n", err)
+		atomic.StoreInt32(&old.recycling, 0)
+		return
+	}
+
+	r.mu.Lock()
+	if r.closed || r.process != old {
+		r.mu.Unlock()
+		fresh.debugger.ExitProcess()
+		return
+	}
+	r.process = fresh
+	r.recycles++
+	r.mu.Unlock()
+	log.Printf("chrome recycled (%s), now on port %d\n", reason, port)
+
+	old.inflight.Wait()
+	old.debugger.ExitProcess()
+}
+
+// monitor periodically recycles the current process if it
+// is older or larger than the recycle policy allows
+func (r *chromeRenderer) monitor() {
+	t := time.NewTicker(recycleCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-t.C:
+		}
+
+		r.mu.RLock()
+		p, policy := r.process, r.recycle
+		r.mu.RUnlock()
+
+		if policy.MaxAge > 0 && time.Since(p.started) >= policy.MaxAge {
+			r.replace(p, "age limit reached")
+			continue
+		}
+		if policy.MaxRSS > 0 {
+			rss, err := processRSS(p.port)
+			if err != nil {
+				log.Printf("reading chrome memory usage failed: %s\n", err)
+				continue
+			}
+			if rss >= policy.MaxRSS {
+				r.replace(p, "memory limit reached")
+			}
+		}
+	}
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, errors.Wrap(err, "finding free port failed")
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
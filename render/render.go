@@ -1,131 +1,321 @@
 package render
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
-	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/wirepair/gcd"
-	"github.com/wirepair/gcd/gcdapi"
 )
 
 // ErrPageLoadTimeout is returned when the page did not fire the "load" event
 // before the timeout expired
 var ErrPageLoadTimeout = errors.New("timed out waiting for page load")
 
+// ErrSelectorTimeout is returned when the page loaded but no element matched
+// the wait selector before the timeout expired
+var ErrSelectorTimeout = errors.New("timed out waiting for selector")
+
 // Renderer is the interface implemented by renderers capable of
 // fetching a webpage and returning the HTML after JavaScript has run
 type Renderer interface {
-	Render(string) (*Result, error)
+	Render(context.Context, string, Options) (*Result, error)
 	SetPageLoadTimeout(time.Duration)
+	SetTabLimit(max, queue int, wait time.Duration)
+	SetRecyclePolicy(RecyclePolicy)
+	Stats() Stats
 	Close()
 }
 
 // Result describes the result of the rendering operation
 type Result struct {
-	URL      string
-	HTML     string
+	URL string
+	// FinalURL is the URL of the page after following redirects
+	FinalURL  string
+	Redirects []Redirect
+	HTML      string
+	// GzipHTML is the gzip compressed HTML, set instead of HTML by
+	// caches returning pages to clients that accept gzip
+	GzipHTML []byte
 	Status   int
 	Etag     string
-	Duration time.Duration
+	// Headers are sent along with the result
+	Headers http.Header
+	// CacheHeaders are the origin's Cache-Control and Expires headers,
+	// which decide how long the result may be cached
+	CacheHeaders http.Header
+	Duration     time.Duration
+	// Device is the class of device the page was rendered for
+	Device Device
+	// ReturnRedirects is set if redirects from the origin were
+	// returned instead of followed
+	ReturnRedirects bool
+	// FreshUntil is when a cached result becomes stale and should be
+	// rendered again. It is zero for results that never become stale.
+	FreshUntil time.Time
+}
+
+// Redirect describes a redirect response from the origin
+type Redirect struct {
+	URL      string
+	Status   int
+	Location string
 }
 
+const (
+	// DefaultMaxTabs is the default maximum number of concurrently open tabs
+	DefaultMaxTabs = 20
+	// DefaultMaxQueue is the default number of renders allowed to wait for a tab
+	DefaultMaxQueue = 100
+	// DefaultQueueTimeout is the default time a render will wait for a tab
+	DefaultQueueTimeout = 10 * time.Second
+)
+
 type chromeRenderer struct {
-	debugger *gcd.Gcd
-	timeout  time.Duration
+	chromePath string
+	userDir    string
+	port       int
+	timeout    time.Duration
+	tabs       *tabLimiter
+
+	mu       sync.RWMutex
+	process  *chromeProcess
+	recycle  RecyclePolicy
+	restarts int
+	recycles int
+	closed   bool
+	done     chan struct{}
 }
 
+// DefaultDebugPort is the remote debugging port used by NewRenderer
+const DefaultDebugPort = 9222
+
 // NewRenderer launches a headless Google Chrome instance
 // ready to render pages
 func NewRenderer() (Renderer, error) {
+	return newChromeRenderer(os.TempDir(), DefaultDebugPort)
+}
+
+func newChromeRenderer(userDir string, port int) (*chromeRenderer, error) {
 	chromePath := "/Applications/Google Chrome Canary.app/Contents/MacOS/Google Chrome Canary"
 	if os.Getenv("CHROME_PATH") != "" {
 		chromePath = os.Getenv("CHROME_PATH")
 	}
 
-	debugger := gcd.NewChromeDebugger()
-	debugger.SetTerminationHandler(func(reason string) {
-		log.Printf("chrome termination: %s\n", reason)
-	})
-	debugger.AddFlags([]string{"--headless", "--disable-gpu"})
-	debugger.StartProcess(chromePath, os.TempDir(), "9222")
-
-	return &chromeRenderer{
-		debugger: debugger,
-		timeout:  60 * time.Second,
-	}, nil
+	r := &chromeRenderer{
+		chromePath: chromePath,
+		userDir:    userDir,
+		port:       port,
+		timeout:    60 * time.Second,
+		tabs:       newTabLimiter(DefaultMaxTabs, DefaultMaxQueue, DefaultQueueTimeout),
+		done:       make(chan struct{}),
+	}
+	p, err := r.start(port)
+	if err != nil {
+		return nil, err
+	}
+	r.process = p
+	go r.monitor()
+	return r, nil
 }
 
 func (r *chromeRenderer) SetPageLoadTimeout(t time.Duration) {
 	r.timeout = t
 }
 
+// SetTabLimit sets the maximum number of concurrently open tabs, how many
+// renders may queue for a free tab, and how long they may wait.
+// A max of 0 removes the limit.
+func (r *chromeRenderer) SetTabLimit(max, queue int, wait time.Duration) {
+	r.tabs.setLimits(max, queue, wait)
+}
+
+// SetRecyclePolicy sets when the Chrome process is replaced by a fresh one.
+// In-flight renders finish on the old process while new renders use the new one.
+func (r *chromeRenderer) SetRecyclePolicy(p RecyclePolicy) {
+	r.mu.Lock()
+	r.recycle = p
+	r.mu.Unlock()
+}
+
+func (r *chromeRenderer) Stats() Stats {
+	stats := r.tabs.stats()
+	r.mu.RLock()
+	stats.Restarts = r.restarts
+	stats.Recycles = r.recycles
+	r.mu.RUnlock()
+	return stats
+}
+
 func (r *chromeRenderer) Close() {
-	r.debugger.ExitProcess()
+	r.mu.Lock()
+	r.closed = true
+	p := r.process
+	r.mu.Unlock()
+	close(r.done)
+	p.debugger.ExitProcess()
 }
 
-func (r *chromeRenderer) Render(url string) (*Result, error) {
+// prerenderReadyScript is true unless the page set window.prerenderReady
+// to false and has not yet set it to true
+const prerenderReadyScript = "window.prerenderReady !== false"
+
+// contextError translates the error of a done render context
+func contextError(ctx context.Context, proc *chromeProcess) error {
+	if proc.dead() {
+		return ErrChromeTerminated
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrPageLoadTimeout
+	}
+	return ctx.Err()
+}
+
+// Render loads the url in a new tab. The tab is closed and the render abandoned
+// as soon as ctx is done; a ctx deadline shorter than the page load timeout
+// takes precedence over it.
+func (r *chromeRenderer) Render(ctx context.Context, url string, opts Options) (*Result, error) {
 	start := time.Now()
-	navigated := make(chan bool)
-	res := Result{URL: url}
+	navigated := make(chan bool, 1)
+	res := Result{URL: url, Device: opts.Device, ReturnRedirects: opts.ReturnRedirects}
 	var err error
 
-	tab, err := r.debugger.NewTab()
+	timeout := r.timeout
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err = r.tabs.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer r.tabs.release()
+
+	proc := r.acquireProcess()
+	defer r.releaseProcess(proc)
+	// end every wait of the render when Chrome terminates
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		select {
+		case <-proc.terminated:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+	tab, err := proc.debugger.NewTab()
 	if err != nil {
+		if proc.dead() {
+			return nil, ErrChromeTerminated
+		}
 		return nil, errors.Wrap(err, "creating new tab failed")
 	}
-	defer r.debugger.CloseTab(tab)
+	defer proc.debugger.CloseTab(tab)
 	// tab.Debug(true)
 
-	tab.Subscribe("Page.loadEventFired", func(target *gcd.ChromeTarget, v []byte) {
-		navigated <- true
-	})
-
-	tab.Subscribe("Network.responseReceived", func(target *gcd.ChromeTarget, v []byte) {
-		event := &gcdapi.NetworkResponseReceivedEvent{}
-		if err = json.Unmarshal(v, event); err != nil {
-			err = errors.Wrap(err, "getting network response failed")
-			return
-		}
-		r := event.Params.Response
-		res.Status = int(r.Status)
-		if etag, ok := r.Headers["Etag"]; ok {
-			res.Etag = etag.(string)
+	loadEvent := "Page.loadEventFired"
+	if opts.Wait == WaitDOMContentLoaded {
+		loadEvent = "Page.domContentEventFired"
+	}
+	tab.Subscribe(loadEvent, func(target *gcd.ChromeTarget, v []byte) {
+		select {
+		case navigated <- true:
+		default:
 		}
 	})
 
+	scripts := newScriptRunner(tab)
+
 	if _, err = tab.Page.Enable(); err != nil {
 		return nil, errors.Wrap(err, "enabling tab page failed")
 	}
+	tree, err := tab.Page.GetResourceTree()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting tab frame tree failed")
+	}
+	frameID := tree.Frame.Id
+	network := newNetworkTracker(tab, frameID)
 	if _, err = tab.Network.Enable(-1, -1); err != nil {
 		return nil, errors.Wrap(err, "enabling tab network failed")
 	}
+	if _, err = tab.Runtime.Enable(); err != nil {
+		return nil, errors.Wrap(err, "enabling tab runtime failed")
+	}
+	if err = applyOptions(tab, opts); err != nil {
+		return nil, err
+	}
 	if _, err = tab.Page.Navigate(url, ""); err != nil {
 		return nil, errors.Wrap(err, "navigating to url failed: "+url)
 	}
 
+	var redirected <-chan struct{}
+	if opts.ReturnRedirects {
+		redirected = network.redirected
+	}
+
 	select {
-	case <-time.After(r.timeout):
-		return nil, ErrPageLoadTimeout
+	case <-ctx.Done():
+		return nil, contextError(ctx, proc)
+	case <-proc.terminated:
+		return nil, ErrChromeTerminated
+	case <-redirected:
+		redirect := network.redirectChain()[0]
+		res.Redirects = []Redirect{redirect}
+		res.FinalURL = redirect.URL
+		res.Status = redirect.Status
+		res.Headers = http.Header{"Location": {redirect.Location}}
+		res.Duration = time.Since(start)
+		return &res, nil
 	case <-navigated:
 	}
 
-	// events may generate errors
-	if err != nil {
-		return nil, err
+	if opts.Wait == WaitNetworkIdle {
+		idle := opts.IdleTime
+		if idle <= 0 {
+			idle = DefaultIdleTime
+		}
+		if network.waitIdle(ctx, idle) != nil {
+			return nil, contextError(ctx, proc)
+		}
 	}
 
+	finalURL, status, header := network.document()
+	res.FinalURL = finalURL
+	res.Redirects = network.redirectChain()
+	res.Status = status
+	res.Etag = header.Get("Etag")
+	res.Headers = forwardHeaders(header, opts.ForwardHeaders)
+	res.CacheHeaders = cacheHeaders(header)
+
 	// page load event but no network response, assume bad DNS
 	if res.Status == 0 {
 		res.Status = http.StatusNotFound
 	}
 
 	if res.Status == http.StatusOK {
+		// pages opt in to signalling readiness by setting window.prerenderReady = false
+		if scripts.waitFor(ctx, frameID, prerenderReadyScript) != nil {
+			return nil, contextError(ctx, proc)
+		}
+
+		if opts.WaitSelector != "" {
+			if err := scripts.waitFor(ctx, frameID, selectorScript(opts.WaitSelector)); err != nil {
+				if proc.dead() {
+					return nil, ErrChromeTerminated
+				}
+				if err == context.DeadlineExceeded {
+					return nil, ErrSelectorTimeout
+				}
+				return nil, err
+			}
+		}
+
 		doc, err := tab.DOM.GetDocument(1, false)
 		if err != nil {
 			return nil, errors.Wrap(err, "getting tab document failed")
@@ -136,6 +326,10 @@ func (r *chromeRenderer) Render(url string) (*Result, error) {
 		}
 		res.HTML = html
 
+		if err := readMetaTags(scripts, frameID, &res); err != nil {
+			return nil, err
+		}
+
 		if res.Etag == "" {
 			hash := md5.Sum([]byte(res.HTML))
 			res.Etag = hex.EncodeToString(hash[:])
@@ -1,6 +1,7 @@
 package render
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -32,7 +33,7 @@ func TestNotFound(t *testing.T) {
 	}))
 	defer server.Close()
 
-	res, err := r.Render(server.URL)
+	res, err := r.Render(context.Background(), server.URL, Options{})
 	require.NoError(t, err)
 	assert.Equal(t, http.StatusNotFound, res.Status)
 	assert.Empty(t, res.HTML)
@@ -46,7 +47,7 @@ func TestOriginEtag(t *testing.T) {
 	}))
 	defer server.Close()
 
-	res, err := r.Render(server.URL)
+	res, err := r.Render(context.Background(), server.URL, Options{})
 	require.NoError(t, err)
 	assert.Equal(t, res.Status, http.StatusOK)
 	// Chrome adds html tags
@@ -61,7 +62,7 @@ func TestEtagGenerate(t *testing.T) {
 	}))
 	defer server.Close()
 
-	res, err := r.Render(server.URL)
+	res, err := r.Render(context.Background(), server.URL, Options{})
 	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, res.Status)
 	// Chrome adds html tags
@@ -79,7 +80,7 @@ func TestTimeout(t *testing.T) {
 	}))
 	defer server.Close()
 
-	_, err := r.Render(server.URL)
+	_, err := r.Render(context.Background(), server.URL, Options{})
 	assert.Equal(t, ErrPageLoadTimeout, err)
 }
 
@@ -89,8 +90,307 @@ func TestNXDomain(t *testing.T) {
 	}))
 	defer server.Close()
 
-	res, err := r.Render("http://baddomainasdfasdf.com")
+	res, err := r.Render(context.Background(), "http://baddomainasdfasdf.com", Options{})
 	require.NoError(t, err)
 	assert.Equal(t, http.StatusNotFound, res.Status)
 	assert.Empty(t, res.HTML)
 }
+
+func TestChromeRestart(t *testing.T) {
+	cr := r.(*chromeRenderer)
+	restarts := cr.Stats().Restarts
+	cr.current().debugger.ExitProcess()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for cr.Stats().Restarts == restarts && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, restarts+1, cr.Stats().Restarts)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, "<body>data</body>")
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.Status)
+}
+
+func TestRecycle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, "<body>data</body>")
+	}))
+	defer server.Close()
+
+	cr := r.(*chromeRenderer)
+	cr.SetRecyclePolicy(RecyclePolicy{MaxRenders: 1})
+	defer cr.SetRecyclePolicy(RecyclePolicy{})
+	recycles := cr.Stats().Recycles
+	old := cr.current()
+
+	_, err := r.Render(context.Background(), server.URL, Options{})
+	require.NoError(t, err)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for cr.Stats().Recycles == recycles && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, recycles+1, cr.Stats().Recycles)
+	assert.NotEqual(t, old, cr.current())
+
+	cr.SetRecyclePolicy(RecyclePolicy{})
+	res, err := r.Render(context.Background(), server.URL, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.Status)
+}
+
+func TestCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := r.Render(ctx, server.URL, Options{})
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestContextDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := r.Render(ctx, server.URL, Options{})
+	assert.Equal(t, ErrPageLoadTimeout, err)
+}
+
+func TestOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		session := ""
+		if cookie, err := r.Cookie("session"); err == nil {
+			session = cookie.Value
+		}
+		fmt.Fprintf(w, "<body>%s|%s|%s</body>", r.UserAgent(), r.Header.Get("X-Site"), session)
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{
+		UserAgent: "prerender-test",
+		Headers:   map[string]string{"X-Site": "one"},
+		Cookies:   []*http.Cookie{{Name: "session", Value: "abc"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "<html><head></head><body>prerender-test|one|abc</body></html>", res.HTML)
+
+	// the cookies of one request are not sent by later renders
+	res, err = r.Render(context.Background(), server.URL, Options{UserAgent: "prerender-test"})
+	require.NoError(t, err)
+	assert.Equal(t, "<html><head></head><body>prerender-test||</body></html>", res.HTML)
+}
+
+func TestDevice(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprintf(w, `<body data-ua="%s"><script>
+document.body.setAttribute("data-width", window.innerWidth);
+document.body.setAttribute("data-touch", "ontouchstart" in window);
+</script></body>`, r.UserAgent())
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{Device: DeviceMobile})
+	require.NoError(t, err)
+	assert.Equal(t, DeviceMobile, res.Device)
+	assert.Contains(t, res.HTML, `data-ua="`+devicePresets[DeviceMobile].userAgent+`"`)
+	assert.Contains(t, res.HTML, `data-width="412"`)
+	assert.Contains(t, res.HTML, `data-touch="true"`)
+}
+
+func TestWaitNetworkIdle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/data" {
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, "loaded")
+			return
+		}
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, `<body><script>
+window.onload = function() {
+  var xhr = new XMLHttpRequest();
+  xhr.onload = function() { document.body.setAttribute("data-state", xhr.responseText); };
+  xhr.open("GET", "/data");
+  xhr.send();
+};
+</script></body>`)
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{Wait: WaitNetworkIdle, IdleTime: 100 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Contains(t, res.HTML, `data-state="loaded"`)
+}
+
+func TestPrerenderReady(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, `<body><script>
+window.prerenderReady = false;
+setTimeout(function() {
+  document.body.setAttribute("data-state", "ready");
+  window.prerenderReady = true;
+}, 200);
+</script></body>`)
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{})
+	require.NoError(t, err)
+	assert.Contains(t, res.HTML, `data-state="ready"`)
+}
+
+func TestPrerenderReadyTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, `<body><script>window.prerenderReady = false;</script></body>`)
+	}))
+	defer server.Close()
+
+	_, err := r.Render(context.Background(), server.URL, Options{Timeout: 500 * time.Millisecond})
+	assert.Equal(t, ErrPageLoadTimeout, err)
+}
+
+func TestWaitSelector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, `<body><div id="app"></div><script>
+setTimeout(function() {
+  document.getElementById("app").innerHTML = '<p data-loaded="true">done</p>';
+}, 200);
+</script></body>`)
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{WaitSelector: "#app [data-loaded]"})
+	require.NoError(t, err)
+	assert.Contains(t, res.HTML, `<p data-loaded="true">done</p>`)
+}
+
+func TestWaitSelectorTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, `<body><div id="app"></div></body>`)
+	}))
+	defer server.Close()
+
+	_, err := r.Render(context.Background(), server.URL, Options{
+		Timeout:      500 * time.Millisecond,
+		WaitSelector: "#app [data-loaded]",
+	})
+	assert.Equal(t, ErrSelectorTimeout, err)
+}
+
+func TestMetaStatusCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html")
+		fmt.Fprint(w, `<head><meta name="prerender-status-code" content="301">
+<meta name="prerender-header" content="Location: https://netlify.com/new"></head><body></body>`)
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMovedPermanently, res.Status)
+	assert.Equal(t, "https://netlify.com/new", res.Headers.Get("Location"))
+}
+
+func TestFailingSubresources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Add("Etag", "documentetag")
+			w.Header().Add("Content-Type", "text/html")
+			fmt.Fprint(w, `<body><img src="/missing.png"><img src="/pixel.gif"></body>`)
+		case "/pixel.gif":
+			w.Header().Add("Etag", "pixeletag")
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.Equal(t, "documentetag", res.Etag)
+}
+
+func TestNotFoundWithSubresources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/style.css" {
+			w.Header().Add("Content-Type", "text/css")
+			fmt.Fprint(w, "body {}")
+			return
+		}
+		w.Header().Add("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<head><link rel="stylesheet" href="/style.css"></head><body>not found</body>`)
+	}))
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.Status)
+	assert.Empty(t, res.HTML)
+}
+
+func redirectServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/middle", http.StatusMovedPermanently)
+		case "/middle":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		default:
+			w.Header().Add("Content-Type", "text/html")
+			fmt.Fprint(w, "<body>new</body>")
+		}
+	}))
+}
+
+func TestRedirectChain(t *testing.T) {
+	server := redirectServer()
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL+"/old", Options{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.Equal(t, server.URL+"/new", res.FinalURL)
+	assert.Equal(t, []Redirect{
+		{URL: server.URL + "/old", Status: http.StatusMovedPermanently, Location: server.URL + "/middle"},
+		{URL: server.URL + "/middle", Status: http.StatusFound, Location: server.URL + "/new"},
+	}, res.Redirects)
+}
+
+func TestReturnRedirects(t *testing.T) {
+	server := redirectServer()
+	defer server.Close()
+
+	res, err := r.Render(context.Background(), server.URL+"/old", Options{ReturnRedirects: true})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMovedPermanently, res.Status)
+	assert.Equal(t, server.URL+"/middle", res.Headers.Get("Location"))
+	assert.Empty(t, res.HTML)
+}
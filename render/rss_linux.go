@@ -0,0 +1,86 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// processRSS returns the resident memory in bytes of the Chrome browser
+// listening on the debugging port and all of its child processes
+func processRSS(port int) (int64, error) {
+	procs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return 0, errors.Wrap(err, "listing processes failed")
+	}
+
+	flag := []byte(fmt.Sprintf("--remote-debugging-port=%d", port))
+	root := 0
+	children := map[int][]int{}
+	for _, dir := range procs {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		if ppid, err := parentPID(dir); err == nil {
+			children[ppid] = append(children[ppid], pid)
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil {
+			continue
+		}
+		// child processes inherit the flag but are started with a --type
+		if bytes.Contains(cmdline, flag) && !bytes.Contains(cmdline, []byte("--type=")) {
+			root = pid
+		}
+	}
+	if root == 0 {
+		return 0, errors.Errorf("no chrome process found for port %d", port)
+	}
+
+	var total int64
+	pending := []int{root}
+	for len(pending) > 0 {
+		pid := pending[0]
+		pending = append(pending[1:], children[pid]...)
+		if rss, err := residentBytes(pid); err == nil {
+			total += rss
+		}
+	}
+	return total, nil
+}
+
+func parentPID(dir string) (int, error) {
+	stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// the command name may contain spaces, so fields start after its closing paren
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	if len(fields) < 2 {
+		return 0, errors.New("unexpected stat format")
+	}
+	return strconv.Atoi(fields[1])
+}
+
+func residentBytes(pid int) (int64, error) {
+	statm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, errors.New("unexpected statm format")
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package render
+
+import "github.com/pkg/errors"
+
+// processRSS is only supported on Linux
+func processRSS(port int) (int64, error) {
+	return 0, errors.New("memory usage is only available on linux")
+}
@@ -0,0 +1,119 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wirepair/gcd"
+)
+
+// scriptPollInterval is how often a condition is re-evaluated while waiting on it
+const scriptPollInterval = 50 * time.Millisecond
+
+// scriptRunner evaluates JavaScript in the main frame of a tab
+type scriptRunner struct {
+	tab *gcd.ChromeTarget
+
+	mu       sync.Mutex
+	contexts map[string]int
+}
+
+// newScriptRunner tracks the default execution context of each frame,
+// which is required to evaluate scripts. Navigations replace the contexts
+// of a frame. The Runtime domain must be enabled afterwards.
+func newScriptRunner(tab *gcd.ChromeTarget) *scriptRunner {
+	s := &scriptRunner{tab: tab, contexts: map[string]int{}}
+	tab.Subscribe("Runtime.executionContextCreated", func(target *gcd.ChromeTarget, v []byte) {
+		var event struct {
+			Params struct {
+				Context struct {
+					ID      int `json:"id"`
+					AuxData struct {
+						IsDefault bool   `json:"isDefault"`
+						FrameID   string `json:"frameId"`
+					} `json:"auxData"`
+				} `json:"context"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(v, &event); err != nil {
+			return
+		}
+		c := event.Params.Context
+		if c.AuxData.IsDefault {
+			s.mu.Lock()
+			s.contexts[c.AuxData.FrameID] = c.ID
+			s.mu.Unlock()
+		}
+	})
+	tab.Subscribe("Runtime.executionContextDestroyed", func(target *gcd.ChromeTarget, v []byte) {
+		var event struct {
+			Params struct {
+				ExecutionContextID int `json:"executionContextId"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(v, &event); err != nil {
+			return
+		}
+		s.mu.Lock()
+		for frameID, id := range s.contexts {
+			if id == event.Params.ExecutionContextID {
+				delete(s.contexts, frameID)
+			}
+		}
+		s.mu.Unlock()
+	})
+	tab.Subscribe("Runtime.executionContextsCleared", func(target *gcd.ChromeTarget, v []byte) {
+		s.mu.Lock()
+		s.contexts = map[string]int{}
+		s.mu.Unlock()
+	})
+	return s
+}
+
+// eval evaluates the expression in the frame and returns its value
+func (s *scriptRunner) eval(frameID, expression string) (interface{}, error) {
+	s.mu.Lock()
+	id, ok := s.contexts[frameID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, errors.New("no execution context for frame " + frameID)
+	}
+
+	obj, exception, err := s.tab.Runtime.Evaluate(expression, "", false, true, id, true, false, false, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "evaluating script failed")
+	}
+	if exception != nil {
+		return nil, errors.New("evaluating script failed: " + exception.Text)
+	}
+	return obj.Value, nil
+}
+
+// waitFor evaluates the expression until it returns true or ctx is done.
+// Evaluation errors are retried since the frame's execution context is
+// missing while the page navigates, and events arrive asynchronously.
+func (s *scriptRunner) waitFor(ctx context.Context, frameID, expression string) error {
+	for {
+		v, err := s.eval(frameID, expression)
+		if done, _ := v.(bool); err == nil && done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(scriptPollInterval):
+		}
+	}
+}
+
+// selectorScript returns an expression that is true once
+// an element matches the CSS selector
+func selectorScript(selector string) string {
+	// a JSON string is a valid JavaScript string literal
+	quoted, _ := json.Marshal(selector)
+	return "document.querySelector(" + string(quoted) + ") !== null"
+}
@@ -0,0 +1,130 @@
+package render
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrQueueFull is returned when the maximum number of tabs are open
+// and the wait queue cannot accept another render
+var ErrQueueFull = errors.New("render queue is full")
+
+// ErrQueueTimeout is returned when a render waited in the queue
+// longer than allowed without a tab becoming available
+var ErrQueueTimeout = errors.New("timed out waiting for a free tab")
+
+// Stats describes the current load on a renderer
+type Stats struct {
+	ActiveTabs int `json:"active_tabs"`
+	Queued     int `json:"queued"`
+	Restarts   int `json:"restarts"`
+	Recycles   int `json:"recycles"`
+}
+
+// tabLimiter bounds the number of concurrently open tabs and queues
+// renders waiting for a free tab in FIFO order
+type tabLimiter struct {
+	mu       sync.Mutex
+	max      int
+	maxQueue int
+	wait     time.Duration
+	active   int
+	waiters  []chan struct{}
+}
+
+func newTabLimiter(max, maxQueue int, wait time.Duration) *tabLimiter {
+	return &tabLimiter{max: max, maxQueue: maxQueue, wait: wait}
+}
+
+// setLimits changes the limits, waking queued renders if the
+// new maximum allows more tabs. A max of 0 removes the limit.
+func (l *tabLimiter) setLimits(max, maxQueue int, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = max
+	l.maxQueue = maxQueue
+	l.wait = wait
+	for len(l.waiters) > 0 && (l.max <= 0 || l.active < l.max) {
+		l.active++
+		l.wake()
+	}
+}
+
+// acquire waits for a free tab. If ctx is done first, the queue timeout
+// is reported for an expired deadline and ctx.Err() otherwise.
+func (l *tabLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.max <= 0 || l.active < l.max {
+		l.active++
+		l.mu.Unlock()
+		return nil
+	}
+	if len(l.waiters) >= l.maxQueue {
+		l.mu.Unlock()
+		return ErrQueueFull
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	wait := l.wait
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-ready:
+		return nil
+	case <-timeout:
+		return l.abandon(ready, ErrQueueTimeout)
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return l.abandon(ready, ErrQueueTimeout)
+		}
+		return l.abandon(ready, ctx.Err())
+	}
+}
+
+// abandon removes a waiter from the queue. If a tab was handed to the waiter
+// while it was giving up, the tab is kept and nil is returned.
+func (l *tabLimiter) abandon(ready chan struct{}, err error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiters {
+		if w == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *tabLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.waiters) > 0 && (l.max <= 0 || l.active <= l.max) {
+		// hand the tab directly to the next waiter
+		l.wake()
+		return
+	}
+	l.active--
+}
+
+// wake must be called with l.mu held
+func (l *tabLimiter) wake() {
+	ready := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	close(ready)
+}
+
+func (l *tabLimiter) stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{ActiveTabs: l.active, Queued: len(l.waiters)}
+}
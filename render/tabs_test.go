@@ -0,0 +1,67 @@
+package render
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTabLimiterQueueFull(t *testing.T) {
+	l := newTabLimiter(1, 0, time.Second)
+	require.NoError(t, l.acquire(context.Background()))
+	assert.Equal(t, ErrQueueFull, l.acquire(context.Background()))
+	l.release()
+	assert.Equal(t, Stats{}, l.stats())
+}
+
+func TestTabLimiterQueueTimeout(t *testing.T) {
+	l := newTabLimiter(1, 1, 10*time.Millisecond)
+	require.NoError(t, l.acquire(context.Background()))
+	assert.Equal(t, ErrQueueTimeout, l.acquire(context.Background()))
+	assert.Equal(t, Stats{ActiveTabs: 1}, l.stats())
+}
+
+func TestTabLimiterHandoff(t *testing.T) {
+	l := newTabLimiter(1, 1, time.Second)
+	require.NoError(t, l.acquire(context.Background()))
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(context.Background())
+	}()
+	for l.stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	l.release()
+	require.NoError(t, <-acquired)
+	assert.Equal(t, Stats{ActiveTabs: 1}, l.stats())
+}
+
+func TestTabLimiterRaiseLimit(t *testing.T) {
+	l := newTabLimiter(1, 1, time.Second)
+	require.NoError(t, l.acquire(context.Background()))
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(context.Background())
+	}()
+	for l.stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	l.setLimits(2, 1, time.Second)
+	require.NoError(t, <-acquired)
+	assert.Equal(t, Stats{ActiveTabs: 2}, l.stats())
+}
+
+func TestTabLimiterCancel(t *testing.T) {
+	l := newTabLimiter(1, 1, time.Second)
+	require.NoError(t, l.acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, l.acquire(ctx))
+	assert.Equal(t, Stats{ActiveTabs: 1}, l.stats())
+}